@@ -0,0 +1,76 @@
+package clock
+
+import (
+	"fmt"
+	"time"
+)
+
+// DateLayout is the layout of every date used in quota and score keys.
+const DateLayout = "2006-01-02"
+
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func System() Clock { return systemClock{} }
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// Tenants derives each tenant's local calendar day from a single shared Clock,
+// so the processor and the scheduler always agree on day boundaries.
+type Tenants struct {
+	c     Clock
+	zones map[string]*time.Location
+	def   *time.Location
+}
+
+func NewTenants(c Clock, zones map[string]string, defaultZone string) (*Tenants, error) {
+	def := time.UTC
+	if defaultZone != "" {
+		loc, err := time.LoadLocation(defaultZone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid default timezone %q: %w", defaultZone, err)
+		}
+		def = loc
+	}
+	m := make(map[string]*time.Location, len(zones))
+	for tenantID, zone := range zones {
+		loc, err := time.LoadLocation(zone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q for tenant %s: %w", zone, tenantID, err)
+		}
+		m[tenantID] = loc
+	}
+	return &Tenants{c: c, zones: m, def: def}, nil
+}
+
+func (t *Tenants) Location(tenantID string) *time.Location {
+	if loc, ok := t.zones[tenantID]; ok {
+		return loc
+	}
+	return t.def
+}
+
+// Now returns the current instant in the tenant's timezone.
+func (t *Tenants) Now(tenantID string) time.Time {
+	return t.c.Now().In(t.Location(tenantID))
+}
+
+// Date returns the tenant's local calendar day of at, shifted by offset days.
+func (t *Tenants) Date(tenantID string, at time.Time, offset int) string {
+	y, m, d := at.In(t.Location(tenantID)).Date()
+	return time.Date(y, m, d+offset, 12, 0, 0, 0, time.UTC).Format(DateLayout)
+}
+
+func (t *Tenants) Today(tenantID string) string {
+	return t.Date(tenantID, t.c.Now(), 0)
+}
+
+// NextMidnight returns the first local midnight of the tenant strictly after at.
+func (t *Tenants) NextMidnight(tenantID string, after time.Time) time.Time {
+	loc := t.Location(tenantID)
+	y, m, d := after.In(loc).Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+}
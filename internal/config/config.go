@@ -1,6 +1,10 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -28,7 +32,22 @@ type GoogleOAuthConfig struct {
 }
 
 type ZeroBounceConfig struct {
-	ApiKey string
+	ApiKey  string
+	BaseURL string
+	// Policy maps ZeroBounce statuses, or "status/sub_status", to reject,
+	// allow or flag; TenantPolicies override it per tenant.
+	Policy         map[string]string
+	TenantPolicies map[string]map[string]string
+	// CacheTTLs overrides how long results are cached, by ZeroBounce status.
+	CacheTTLs map[string]time.Duration
+	// CreditAlert is the balance below which an alert is raised; the balance
+	// is checked every CreditsInterval.
+	CreditAlert     int
+	CreditsInterval time.Duration
+	// After BreakerThreshold consecutive API failures, calls stop for
+	// BreakerCooldown and addresses are reported unknown.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
 }
 
 type Config struct {
@@ -38,13 +57,87 @@ type Config struct {
 	SenderMap   map[string]string
 	RetryPolicy RetryPolicy
 	WorkerCount int
-	Validator   struct{ DisposableDomains []string }
+	Validator   struct {
+		DisposableDomains []string
+		// Checks is the validation chain run after the syntax check, and
+		// TenantChecks overrides it per tenant.
+		Checks       []string
+		TenantChecks map[string][]string
+		// DNSCacheTTL is how long a domain's DNS check result is kept.
+		DNSCacheTTL time.Duration
+	}
+
+	// QuotaStoreURL selects the quota store by scheme; it defaults to RedisURL.
+	QuotaStoreURL string
+
+	// HistoryURL selects the send history store; empty disables it. Records
+	// older than HistoryRetention are purged daily.
+	HistoryURL       string
+	HistoryRetention time.Duration
+
+	// DedupLease is how long an event claimed for processing is held before
+	// another worker may take it; DedupTTL is how long processed event IDs
+	// are remembered.
+	DedupLease time.Duration
+	DedupTTL   time.Duration
+	// DelayPollInterval is how often events scheduled with sendAt are
+	// checked for being due.
+	DelayPollInterval time.Duration
 
 	SMTP        SMTPConfig
 	GoogleOAuth GoogleOAuthConfig
 
 	QuotaScoreThreshold float64
 	QuotaScaleFactor    float64
+	// ReputationHalfLifeDays is how fast older days fade from the rolling
+	// reputation; ReputationMinSamples is the weight it needs before quota
+	// decisions are made on it.
+	ReputationHalfLifeDays float64
+	ReputationMinSamples   int
+	// ScoreWeights overrides the scoring model's weight per outcome name.
+	ScoreWeights map[string]float64
+
+	TimezoneMap     map[string]string
+	DefaultTimezone string // applies to tenants missing from TimezoneMap
+	// InstanceID identifies this replica in leader election.
+	InstanceID     string
+	LeaderLeaseTTL time.Duration
+	// DailyScoreCron schedules each tenant's score check in its timezone.
+	DailyScoreCron string
+
+	// TenantPlanMap assigns tenants to plans and PlanWeights gives each plan
+	// its share of worker capacity; see TenantWeight.
+	TenantPlanMap map[string]string
+	PlanWeights   map[string]float64
+
+	// SeedPoolFile lists the seed mailboxes as JSON; empty disables the
+	// planner. SeedPlanCron schedules each tenant's planning run in its
+	// timezone.
+	SeedPoolFile string
+	SeedPlanCron string
+	// SeedReplyRate is the fraction of warmup mail seeds reply to, after a
+	// random delay between SeedReplyMinDelay and SeedReplyMaxDelay.
+	SeedReplyRate     float64
+	SeedReplyMinDelay time.Duration
+	SeedReplyMaxDelay time.Duration
+	// SeedRescueDelay is how long after a send the seed's spam folder is
+	// checked for it.
+	SeedRescueDelay time.Duration
+	// PeerPoolTenants opt in to receiving each other's warmup mail, at most
+	// PeerPairCap sends per sender and recipient pair a day.
+	PeerPoolTenants []string
+	PeerPairCap     int
+	// ContentTemplatesFile is a JSON library of warmup templates, replies
+	// and signatures; empty uses the built-in one.
+	ContentTemplatesFile string
+
+	// TrackingSecret keys the per-tenant codes warmup mail is stamped with;
+	// empty disables tracking. TrackingBodyCode also puts the code in the
+	// body, for mailboxes that cannot search headers, and TrackingLabel is
+	// what seed mailboxes file tracked mail under.
+	TrackingSecret   string
+	TrackingBodyCode bool
+	TrackingLabel    string
 
 	ZeroBounce ZeroBounceConfig
 }
@@ -68,6 +161,30 @@ func Load() (*Config, error) {
 	v.SetDefault("RETRY_POLICY_INITIAL_DELAY", "1s")
 	v.SetDefault("QUOTA_SCORE_THRESHOLD", 0.8)
 	v.SetDefault("QUOTA_SCALE_FACTOR", 1.5)
+	v.SetDefault("DEFAULT_TIMEZONE", "UTC")
+	v.SetDefault("DAILY_SCORE_CRON", "0 0 * * *")
+	v.SetDefault("LEADER_LEASE_TTL", "15s")
+	v.SetDefault("REPUTATION_HALF_LIFE_DAYS", 3)
+	v.SetDefault("REPUTATION_MIN_SAMPLES", 20)
+	v.SetDefault("HISTORY_RETENTION", "2160h")
+	v.SetDefault("DEDUP_LEASE", "10m")
+	v.SetDefault("DEDUP_TTL", "24h")
+	v.SetDefault("DELAY_POLL_INTERVAL", "1s")
+	v.SetDefault("SEED_PLAN_CRON", "0 7 * * *")
+	v.SetDefault("SEED_REPLY_RATE", 0.3)
+	v.SetDefault("SEED_REPLY_MIN_DELAY", "10m")
+	v.SetDefault("SEED_REPLY_MAX_DELAY", "4h")
+	v.SetDefault("SEED_RESCUE_DELAY", "15m")
+	v.SetDefault("PEER_PAIR_CAP", 5)
+	v.SetDefault("VALIDATOR_CHECKS", "disposable,dns,zerobounce")
+	v.SetDefault("VALIDATOR_DNS_CACHE_TTL", "1h")
+	v.SetDefault("ZERO_BOUNCE_BASE_URL", "https://api.zerobounce.net/v2")
+	v.SetDefault("ZERO_BOUNCE_CREDIT_ALERT", 1000)
+	v.SetDefault("ZERO_BOUNCE_CREDITS_INTERVAL", "15m")
+	v.SetDefault("ZERO_BOUNCE_BREAKER_THRESHOLD", 5)
+	v.SetDefault("ZERO_BOUNCE_BREAKER_COOLDOWN", "1m")
+	v.SetDefault("TRACKING_BODY_CODE", false)
+	v.SetDefault("TRACKING_LABEL", "Warmup")
 
 	v.BindEnv("QUOTA_SCORE_THRESHOLD")
 	v.BindEnv("QUOTA_SCALE_FACTOR")
@@ -82,16 +199,40 @@ func Load() (*Config, error) {
 	cfg := &Config{}
 	cfg.QueueURL = v.GetString("QUEUE_URL")
 	cfg.RedisURL = v.GetString("REDIS_URL")
+	cfg.QuotaStoreURL = v.GetString("QUOTA_STORE_URL")
+	if cfg.QuotaStoreURL == "" {
+		cfg.QuotaStoreURL = cfg.RedisURL
+	}
+	cfg.HistoryURL = v.GetString("HISTORY_URL")
+	cfg.HistoryRetention = v.GetDuration("HISTORY_RETENTION")
+	cfg.DedupLease = v.GetDuration("DEDUP_LEASE")
+	cfg.DedupTTL = v.GetDuration("DEDUP_TTL")
+	cfg.DelayPollInterval = v.GetDuration("DELAY_POLL_INTERVAL")
 
 	cfg.ProviderMap = v.GetStringMapString("PROVIDER_MAP")
 	cfg.SenderMap = v.GetStringMapString("TENANT_SENDER_MAP")
+	cfg.TimezoneMap = v.GetStringMapString("TENANT_TIMEZONE_MAP")
+	cfg.DefaultTimezone = v.GetString("DEFAULT_TIMEZONE")
+	cfg.DailyScoreCron = v.GetString("DAILY_SCORE_CRON")
+	cfg.LeaderLeaseTTL = v.GetDuration("LEADER_LEASE_TTL")
+	cfg.InstanceID = v.GetString("INSTANCE_ID")
+	if cfg.InstanceID == "" {
+		host, _ := os.Hostname()
+		cfg.InstanceID = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
 
 	cfg.WorkerCount = v.GetInt("WORKER_COUNT")
 
 	cfg.RetryPolicy.MaxRetries = v.GetInt("RETRY_POLICY_MAX_RETRIES")
 	d, _ := time.ParseDuration(v.GetString("RETRY_POLICY_INITIAL_DELAY"))
 	cfg.RetryPolicy.InitialDelay = d
-	cfg.Validator.DisposableDomains = v.GetStringSlice("VALIDATOR_DISPOSABLE_DOMAINS")
+	cfg.Validator.DisposableDomains = splitList(v.GetString("VALIDATOR_DISPOSABLE_DOMAINS"))
+	cfg.Validator.Checks = splitList(v.GetString("VALIDATOR_CHECKS"))
+	cfg.Validator.DNSCacheTTL = v.GetDuration("VALIDATOR_DNS_CACHE_TTL")
+	cfg.Validator.TenantChecks = map[string][]string{}
+	for tenantID, checks := range v.GetStringMapString("TENANT_VALIDATOR_CHECKS") {
+		cfg.Validator.TenantChecks[tenantID] = splitList(checks)
+	}
 
 	cfg.SMTP.Host = v.GetString("SMTP_HOST")
 	cfg.SMTP.Port = v.GetString("SMTP_PORT")
@@ -99,15 +240,90 @@ func Load() (*Config, error) {
 	cfg.SMTP.Pass = v.GetString("SMTP_PASS")
 	cfg.SMTP.From = v.GetString("SMTP_FROM")
 
-	cfg.QuotaScaleFactor = v.GetFloat64("QUOTA_SCORE_THRESHOLD")
+	cfg.QuotaScaleFactor = v.GetFloat64("QUOTA_SCALE_FACTOR")
 	cfg.QuotaScoreThreshold = v.GetFloat64("QUOTA_SCORE_THRESHOLD")
 
+	cfg.ReputationHalfLifeDays = v.GetFloat64("REPUTATION_HALF_LIFE_DAYS")
+	cfg.ReputationMinSamples = v.GetInt("REPUTATION_MIN_SAMPLES")
+
+	cfg.ScoreWeights = map[string]float64{}
+	for outcome, w := range v.GetStringMapString("SCORE_WEIGHTS") {
+		f, err := strconv.ParseFloat(w, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SCORE_WEIGHTS weight for %s: %w", outcome, err)
+		}
+		cfg.ScoreWeights[outcome] = f
+	}
+
+	cfg.SeedPoolFile = v.GetString("SEED_POOL_FILE")
+	cfg.SeedPlanCron = v.GetString("SEED_PLAN_CRON")
+	cfg.SeedReplyRate = v.GetFloat64("SEED_REPLY_RATE")
+	cfg.SeedReplyMinDelay = v.GetDuration("SEED_REPLY_MIN_DELAY")
+	cfg.SeedReplyMaxDelay = v.GetDuration("SEED_REPLY_MAX_DELAY")
+	cfg.SeedRescueDelay = v.GetDuration("SEED_RESCUE_DELAY")
+	cfg.PeerPoolTenants = splitList(v.GetString("PEER_POOL_TENANTS"))
+	cfg.PeerPairCap = v.GetInt("PEER_PAIR_CAP")
+	cfg.ContentTemplatesFile = v.GetString("CONTENT_TEMPLATES_FILE")
+	cfg.TrackingSecret = v.GetString("TRACKING_SECRET")
+	cfg.TrackingBodyCode = v.GetBool("TRACKING_BODY_CODE")
+	cfg.TrackingLabel = v.GetString("TRACKING_LABEL")
+
+	cfg.TenantPlanMap = v.GetStringMapString("TENANT_PLAN_MAP")
+	cfg.PlanWeights = map[string]float64{}
+	for plan, w := range v.GetStringMapString("PLAN_WEIGHTS") {
+		f, err := strconv.ParseFloat(w, 64)
+		if err != nil || f <= 0 {
+			return nil, fmt.Errorf("invalid PLAN_WEIGHTS weight for %s: %q", plan, w)
+		}
+		cfg.PlanWeights[plan] = f
+	}
+
 	cfg.GoogleOAuth.GoogleCredentialsJSON = v.GetString("GOOGLE_CREDENTIALS_JSON")
 	cfg.GoogleOAuth.GoogleAccessToken = v.GetString("GOOGLE_ACCESS_TOKEN")
 	cfg.GoogleOAuth.GoogleRefreshToken = v.GetString("GOOGLE_REFRESH_TOKEN")
 	cfg.GoogleOAuth.GoogleEmailSender = v.GetString("GOOGLE_EMAIL_SENDER")
 
 	cfg.ZeroBounce.ApiKey = v.GetString("ZERO_BOUNCE_API_KEY")
+	cfg.ZeroBounce.BaseURL = v.GetString("ZERO_BOUNCE_BASE_URL")
+	cfg.ZeroBounce.Policy = v.GetStringMapString("ZERO_BOUNCE_POLICY")
+	cfg.ZeroBounce.TenantPolicies = map[string]map[string]string{}
+	if raw := v.GetString("TENANT_ZERO_BOUNCE_POLICY"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &cfg.ZeroBounce.TenantPolicies); err != nil {
+			return nil, fmt.Errorf("invalid TENANT_ZERO_BOUNCE_POLICY: %w", err)
+		}
+	}
+	cfg.ZeroBounce.CacheTTLs = map[string]time.Duration{}
+	for status, ttl := range v.GetStringMapString("ZERO_BOUNCE_CACHE_TTLS") {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ZERO_BOUNCE_CACHE_TTLS ttl for %s: %w", status, err)
+		}
+		cfg.ZeroBounce.CacheTTLs[status] = d
+	}
+	cfg.ZeroBounce.CreditAlert = v.GetInt("ZERO_BOUNCE_CREDIT_ALERT")
+	cfg.ZeroBounce.CreditsInterval = v.GetDuration("ZERO_BOUNCE_CREDITS_INTERVAL")
+	cfg.ZeroBounce.BreakerThreshold = v.GetInt("ZERO_BOUNCE_BREAKER_THRESHOLD")
+	cfg.ZeroBounce.BreakerCooldown = v.GetDuration("ZERO_BOUNCE_BREAKER_COOLDOWN")
 
 	return cfg, nil
 }
+
+// splitList parses a comma-separated list, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// TenantWeight is the tenant's plan weight, or 1 when the tenant or its plan
+// is not configured.
+func (c *Config) TenantWeight(tenantID string) float64 {
+	if w, ok := c.PlanWeights[c.TenantPlanMap[tenantID]]; ok {
+		return w
+	}
+	return 1
+}
@@ -0,0 +1,253 @@
+package content
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"github.com/ilivestrong/email_warmup_service/internal/clock"
+	"github.com/ilivestrong/email_warmup_service/internal/dedup"
+)
+
+// Template is one warmup email. Subject and Text are text/template and HTML
+// is html/template source; all three may use spintax. HTML is optional.
+type Template struct {
+	Name    string `json:"name"`
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html,omitempty"`
+}
+
+// Library is the content warmup mail is generated from. Replies are bodies
+// for seed replies; Signatures are appended to every email.
+type Library struct {
+	Templates  []Template `json:"templates"`
+	Replies    []string   `json:"replies"`
+	Signatures []string   `json:"signatures"`
+}
+
+// LoadFile reads a JSON library. An empty path gives the built-in one.
+func LoadFile(path string) (*Library, error) {
+	if path == "" {
+		return Default(), nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lib := new(Library)
+	if err := json.Unmarshal(b, lib); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := lib.check(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return lib, nil
+}
+
+// check parses every template so that mistakes surface at startup.
+func (l *Library) check() error {
+	if len(l.Templates) == 0 || len(l.Replies) == 0 {
+		return errors.New("content library needs templates and replies")
+	}
+	for _, t := range l.Templates {
+		for _, src := range []string{t.Subject, t.Text} {
+			if _, err := template.New(t.Name).Parse(src); err != nil {
+				return err
+			}
+		}
+		if _, err := htmltemplate.New(t.Name).Parse(t.HTML); err != nil {
+			return err
+		}
+	}
+	for _, src := range append(l.Replies, l.Signatures...) {
+		if _, err := template.New("").Parse(src); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Vars are the per-recipient values templates can use, e.g.
+// {{.FirstName}} or {{.Date.Weekday}}.
+type Vars struct {
+	FirstName      string // recipient's
+	SenderName     string
+	SenderEmail    string
+	RecipientEmail string
+	Company        string // recipient's, from the domain
+	Date           time.Time
+}
+
+// VarsFor derives the variables of a send from its addresses.
+func VarsFor(from, to string, at time.Time) Vars {
+	first := nameOf(to)
+	if first == "" {
+		first = "there"
+	}
+	sender := nameOf(from)
+	if sender == "" {
+		sender = "The " + companyOf(from) + " team"
+	}
+	return Vars{
+		FirstName:      first,
+		SenderName:     sender,
+		SenderEmail:    from,
+		RecipientEmail: to,
+		Company:        companyOf(to),
+		Date:           at,
+	}
+}
+
+// nameOf guesses a first name from the local part of an address, e.g.
+// "jane.doe@x.com" gives "Jane". Role and numbered addresses give "".
+func nameOf(addr string) string {
+	local, _, _ := strings.Cut(addr, "@")
+	first := strings.FieldsFunc(local, func(r rune) bool { return r == '.' || r == '_' || r == '-' || r == '+' })
+	if len(first) == 0 || strings.IndexFunc(first[0], unicode.IsDigit) >= 0 {
+		return ""
+	}
+	switch name := strings.ToLower(first[0]); name {
+	case "info", "sales", "support", "admin", "hello", "contact", "team", "noreply", "no":
+		return ""
+	default:
+		return strings.ToUpper(name[:1]) + name[1:]
+	}
+}
+
+// companyOf names the organisation of an address after its domain, e.g.
+// "jane@acme.co" gives "Acme".
+func companyOf(addr string) string {
+	_, domain, _ := strings.Cut(addr, "@")
+	labels := strings.Split(domain, ".")
+	name := labels[0]
+	if len(labels) >= 2 {
+		name = labels[len(labels)-2]
+	}
+	if name == "" {
+		return ""
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// Message is generated content.
+type Message struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Generator renders library content. A body is handed out at most once per
+// day: its hash is claimed in seen, and a colliding render is spun again.
+type Generator struct {
+	lib  *Library
+	seen dedup.Store
+}
+
+// maxAttempts bounds the renders tried before giving up on a unique body.
+const maxAttempts = 10
+
+var ErrNotUnique = errors.New("no unique content left")
+
+func NewGenerator(lib *Library, seen dedup.Store) *Generator {
+	return &Generator{lib: lib, seen: seen}
+}
+
+// Warmup renders a random template for the send.
+func (g *Generator) Warmup(ctx context.Context, v Vars) (Message, error) {
+	return g.unique(ctx, v, func() (Message, error) {
+		t := g.lib.Templates[rand.IntN(len(g.lib.Templates))]
+		var m Message
+		var err error
+		if m.Subject, err = renderText(t.Subject, v); err != nil {
+			return m, err
+		}
+		if m.Text, err = renderText(t.Text, v); err != nil {
+			return m, err
+		}
+		if t.HTML != "" {
+			if m.HTML, err = renderHTML(t.HTML, v); err != nil {
+				return m, err
+			}
+		}
+		return g.sign(m, v)
+	})
+}
+
+// Reply renders a random reply body; the subject is left to the caller.
+func (g *Generator) Reply(ctx context.Context, v Vars) (Message, error) {
+	return g.unique(ctx, v, func() (Message, error) {
+		text, err := renderText(g.lib.Replies[rand.IntN(len(g.lib.Replies))], v)
+		if err != nil {
+			return Message{}, err
+		}
+		return g.sign(Message{Text: text}, v)
+	})
+}
+
+func (g *Generator) unique(ctx context.Context, v Vars, render func() (Message, error)) (Message, error) {
+	date := v.Date.Format(clock.DateLayout)
+	for range maxAttempts {
+		m, err := render()
+		if err != nil {
+			return m, err
+		}
+		sum := sha256.Sum256([]byte(m.Text + "\x00" + m.HTML))
+		id := "content:" + date + ":" + hex.EncodeToString(sum[:])
+		st, err := g.seen.Claim(ctx, "", id)
+		if err != nil {
+			return m, err
+		}
+		if st == dedup.Claimed {
+			return m, g.seen.Done(ctx, "", id)
+		}
+	}
+	return Message{}, ErrNotUnique
+}
+
+// sign appends a random signature to both bodies.
+func (g *Generator) sign(m Message, v Vars) (Message, error) {
+	if len(g.lib.Signatures) == 0 {
+		return m, nil
+	}
+	sig, err := renderText(g.lib.Signatures[rand.IntN(len(g.lib.Signatures))], v)
+	if err != nil {
+		return m, err
+	}
+	m.Text += "\n\n" + sig
+	if m.HTML != "" {
+		m.HTML += "<p>" + strings.ReplaceAll(htmltemplate.HTMLEscapeString(sig), "\n", "<br>") + "</p>"
+	}
+	return m, nil
+}
+
+func renderText(src string, v Vars) (string, error) {
+	t, err := template.New("").Parse(Spin(src))
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	err = t.Execute(&buf, v)
+	return buf.String(), err
+}
+
+func renderHTML(src string, v Vars) (string, error) {
+	t, err := htmltemplate.New("").Parse(Spin(src))
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	err = t.Execute(&buf, v)
+	return buf.String(), err
+}
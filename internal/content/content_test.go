@@ -0,0 +1,176 @@
+package content
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ilivestrong/email_warmup_service/internal/dedup"
+)
+
+var day = time.Date(2025, 1, 2, 9, 0, 0, 0, time.UTC) // a Thursday
+
+func TestVarsFor(t *testing.T) {
+	tests := []struct {
+		from, to               string
+		first, sender, company string
+	}{
+		{"jane.doe@acme.com", "bob_smith@globex.co.uk", "Bob", "Jane", "Co"},
+		{"info@acme.com", "sales@initech.io", "there", "The Acme team", "Initech"},
+		{"x9@acme.com", "42@initech.io", "there", "The Acme team", "Initech"},
+		{"MAX+news@acme.com", "anna-lee@sub.initech.io", "Anna", "Max", "Initech"},
+	}
+	for _, tc := range tests {
+		v := VarsFor(tc.from, tc.to, day)
+		if v.FirstName != tc.first || v.SenderName != tc.sender || v.Company != tc.company ||
+			v.SenderEmail != tc.from || v.RecipientEmail != tc.to || !v.Date.Equal(day) {
+			t.Errorf("VarsFor(%q, %q) = %+v", tc.from, tc.to, v)
+		}
+	}
+}
+
+func TestRenderVars(t *testing.T) {
+	v := VarsFor("jane@acme.com", "bob@globex.com", day)
+	got, err := renderText("{Hi|Hi} {{.FirstName}} at {{.Company}}, {{.Date.Weekday}} from {{.SenderName}} <{{.SenderEmail}}> to {{.RecipientEmail}}", v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Hi Bob at Globex, Thursday from Jane <jane@acme.com> to bob@globex.com"; got != want {
+		t.Fatalf("renderText = %q, want %q", got, want)
+	}
+
+	v.FirstName = "<b>Bob</b>"
+	html, err := renderHTML("<p>{{.FirstName}}</p>", v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if html != "<p>&lt;b&gt;Bob&lt;/b&gt;</p>" {
+		t.Fatalf("renderHTML did not escape: %q", html)
+	}
+
+	if _, err := renderText("{{.Nope}}", v); err == nil {
+		t.Fatal("unknown variable rendered without error")
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	if err := Default().check(); err != nil {
+		t.Fatalf("built-in library: %v", err)
+	}
+	dir := t.TempDir()
+	write := func(name, body string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	tests := []struct {
+		name, body string
+		ok         bool
+	}{
+		{"ok.json", `{"templates":[{"name":"a","subject":"S","text":"Hi {{.FirstName}}"}],"replies":["Thanks"]}`, true},
+		{"empty.json", `{"templates":[],"replies":["Thanks"]}`, false},
+		{"no-replies.json", `{"templates":[{"name":"a","subject":"S","text":"T"}]}`, false},
+		{"bad-template.json", `{"templates":[{"name":"a","subject":"S","text":"{{.FirstName"}],"replies":["Thanks"]}`, false},
+		{"bad-html.json", `{"templates":[{"name":"a","subject":"S","text":"T","html":"{{if}}"}],"replies":["Thanks"]}`, false},
+		{"bad-signature.json", `{"templates":[{"name":"a","subject":"S","text":"T"}],"replies":["Thanks"],"signatures":["{{end}}"]}`, false},
+		{"bad-json.json", `{`, false},
+	}
+	for _, tc := range tests {
+		_, err := LoadFile(write(tc.name, tc.body))
+		if (err == nil) != tc.ok {
+			t.Errorf("LoadFile(%s) error = %v, want ok %v", tc.name, err, tc.ok)
+		}
+	}
+}
+
+// scriptedSeen answers Claim with the given statuses in turn and Claimed once
+// they run out.
+type scriptedSeen struct {
+	statuses []dedup.Status
+	err      error
+	claims   []string
+	done     int
+}
+
+func (s *scriptedSeen) Claim(_ context.Context, _, id string) (dedup.Status, error) {
+	s.claims = append(s.claims, id)
+	if s.err != nil {
+		return 0, s.err
+	}
+	if len(s.statuses) == 0 {
+		return dedup.Claimed, nil
+	}
+	st := s.statuses[0]
+	s.statuses = s.statuses[1:]
+	return st, nil
+}
+
+func (s *scriptedSeen) Done(context.Context, string, string) error { s.done++; return nil }
+
+func (s *scriptedSeen) Release(context.Context, string, string) error { return nil }
+
+func fixedLibrary() *Library {
+	return &Library{
+		Templates: []Template{{Name: "t", Subject: "Hello", Text: "Hi {{.FirstName}}"}},
+		Replies:   []string{"Thanks"},
+	}
+}
+
+func TestUniqueRetries(t *testing.T) {
+	seen := &scriptedSeen{statuses: []dedup.Status{dedup.Processed, dedup.Leased}}
+	m, err := NewGenerator(fixedLibrary(), seen).Warmup(context.Background(), VarsFor("a@x.com", "bob@y.com", day))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Subject != "Hello" || m.Text != "Hi Bob" {
+		t.Fatalf("Warmup = %+v", m)
+	}
+	if len(seen.claims) != 3 || seen.done != 1 {
+		t.Fatalf("claimed %d times and marked done %d times, want 3 and 1", len(seen.claims), seen.done)
+	}
+	if !strings.HasPrefix(seen.claims[0], "content:2025-01-02:") {
+		t.Fatalf("claim ID %q is not scoped by day", seen.claims[0])
+	}
+}
+
+func TestUniqueGivesUp(t *testing.T) {
+	statuses := make([]dedup.Status, maxAttempts)
+	for i := range statuses {
+		statuses[i] = dedup.Processed
+	}
+	seen := &scriptedSeen{statuses: statuses}
+	_, err := NewGenerator(fixedLibrary(), seen).Reply(context.Background(), VarsFor("a@x.com", "b@y.com", day))
+	if !errors.Is(err, ErrNotUnique) {
+		t.Fatalf("Reply = %v, want ErrNotUnique", err)
+	}
+	if len(seen.claims) != maxAttempts || seen.done != 0 {
+		t.Fatalf("claimed %d times and marked done %d times, want %d and 0", len(seen.claims), seen.done, maxAttempts)
+	}
+
+	boom := errors.New("boom")
+	if _, err := NewGenerator(fixedLibrary(), &scriptedSeen{err: boom}).Reply(context.Background(), VarsFor("a@x.com", "b@y.com", day)); !errors.Is(err, boom) {
+		t.Fatalf("Reply with a failing store = %v, want %v", err, boom)
+	}
+}
+
+func TestUniquePerDay(t *testing.T) {
+	gen := NewGenerator(fixedLibrary(), dedup.NewMemoryStore(time.Minute, time.Hour))
+	ctx := context.Background()
+	v := VarsFor("a@x.com", "bob@y.com", day)
+	if _, err := gen.Warmup(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gen.Warmup(ctx, v); !errors.Is(err, ErrNotUnique) {
+		t.Fatalf("second identical body on the same day = %v, want ErrNotUnique", err)
+	}
+	v.Date = day.AddDate(0, 0, 1)
+	if _, err := gen.Warmup(ctx, v); err != nil {
+		t.Fatalf("identical body on the next day: %v", err)
+	}
+}
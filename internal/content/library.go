@@ -0,0 +1,36 @@
+package content
+
+// Default is the built-in library, used when no file is configured.
+func Default() *Library {
+	return &Library{
+		Templates: []Template{
+			{
+				Name:    "check-in",
+				Subject: "{Quick|Short|Small} {question|check-in}{| for you}",
+				Text:    "{Hi|Hello|Hey} {{.FirstName}},\n\n{Just checking in|Wanted to check in|Quick check-in} to see how things are going {on your end|at {{.Company}}|this {{.Date.Weekday}}}. {Let me know when you have a minute.|Do you have a minute to catch up?|Happy to chat whenever suits you.}",
+			},
+			{
+				Name:    "notes",
+				Subject: "{Notes|Thoughts|A few notes} from {today|this week|our call}",
+				Text:    "{Hello|Hi} {{.FirstName}},\n\n{I went through|I had a look at|I read through} the {notes|draft|summary} and {they look|it looks} {good|great|solid} to me. {Happy to go over them together if that helps.|Shall we walk through it together?|Let me know if you want to go through it.}",
+				HTML:    "<p>{Hello|Hi} {{.FirstName}},</p><p>{I went through|I had a look at|I read through} the {notes|draft|summary} and {they look|it looks} {good|great|solid} to me.</p>",
+			},
+			{
+				Name:    "call",
+				Subject: "{Time for a call|Catching up|Call} {this week|on {{.Date.Weekday}}|soon}?",
+				Text:    "{Hi there|Hi {{.FirstName}}|Hello {{.FirstName}}},\n\n{Hope your week is off to a good start.|Hope all is well.|Hope you're doing well.} {Do you have time for a short call|Could we find {15|20|30} minutes|Are you free for a quick call} {on Thursday|later this week|early next week}?",
+			},
+		},
+		Replies: []string{
+			"{Thanks|Thank you} for the {note|message|update}, {sounds good to me|that works for me|all good here}.",
+			"{Got it|Understood}, {thank you|thanks}! {I'll take a look and get back to you.|I'll reply properly {later today|tomorrow}.}",
+			"{Appreciate you sending|Thanks for sending} this over. {Thursday|Friday|Early next week} works for me.",
+			"{Thanks|Cheers}! That all makes sense. {Talk soon.|Speak soon.|Chat later.}",
+		},
+		Signatures: []string{
+			"{Best|Thanks|Cheers|Kind regards},\n{{.SenderName}}",
+			"{Best wishes|All the best|Regards}\n{{.SenderName}}",
+			"{Thanks again|Talk soon}\n— {{.SenderName}}",
+		},
+	}
+}
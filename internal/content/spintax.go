@@ -0,0 +1,90 @@
+package content
+
+import (
+	"math/rand/v2"
+	"strings"
+)
+
+// Spin expands spintax: every {a|b|c} group is replaced by one of its
+// options, picked at random. Groups nest. Template actions such as
+// {{.FirstName}} are left for the template to execute.
+func Spin(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); {
+		if isAction(s, i) {
+			end := actionEnd(s, i)
+			out.WriteString(s[i:end])
+			i = end
+			continue
+		}
+		if s[i] == '{' {
+			if end := groupEnd(s, i); end > 0 {
+				opts := options(s[i+1 : end])
+				out.WriteString(Spin(opts[rand.IntN(len(opts))]))
+				i = end + 1
+				continue
+			}
+		}
+		out.WriteByte(s[i])
+		i++
+	}
+	return out.String()
+}
+
+// isAction reports whether a template action starts at i. In "{{{" the first
+// brace opens a group whose first option is an action.
+func isAction(s string, i int) bool {
+	return strings.HasPrefix(s[i:], "{{") && !strings.HasPrefix(s[i:], "{{{")
+}
+
+// actionEnd returns the index just past the template action starting at i.
+func actionEnd(s string, i int) int {
+	if j := strings.Index(s[i+2:], "}}"); j >= 0 {
+		return i + 2 + j + 2
+	}
+	return len(s)
+}
+
+// groupEnd returns the index of the brace closing the group opened at i, or
+// -1 when it is not closed.
+func groupEnd(s string, i int) int {
+	depth := 0
+	for j := i; j < len(s); {
+		switch {
+		case isAction(s, j):
+			j = actionEnd(s, j)
+			continue
+		case s[j] == '{':
+			depth++
+		case s[j] == '}':
+			if depth--; depth == 0 {
+				return j
+			}
+		}
+		j++
+	}
+	return -1
+}
+
+// options splits a group's body on the bars that are not inside a nested
+// group or an action.
+func options(s string) []string {
+	var opts []string
+	depth, start := 0, 0
+	for j := 0; j < len(s); {
+		switch {
+		case isAction(s, j):
+			j = actionEnd(s, j)
+			continue
+		case s[j] == '{':
+			depth++
+		case s[j] == '}':
+			depth--
+		case s[j] == '|' && depth == 0:
+			opts = append(opts, s[start:j])
+			start = j + 1
+		}
+		j++
+	}
+	return append(opts, s[start:])
+}
@@ -0,0 +1,48 @@
+package content
+
+import (
+	"slices"
+	"testing"
+)
+
+// spins collects the distinct expansions of src over enough runs to see
+// every one of a handful of options.
+func spins(src string) []string {
+	seen := map[string]bool{}
+	for range 500 {
+		seen[Spin(src)] = true
+	}
+	var out []string
+	for s := range seen {
+		out = append(out, s)
+	}
+	slices.Sort(out)
+	return out
+}
+
+func TestSpin(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{"plain", "no groups here", []string{"no groups here"}},
+		{"group", "{Hi|Hello} there", []string{"Hello there", "Hi there"}},
+		{"empty option", "call{| me}", []string{"call", "call me"}},
+		{"nested", "{a|b {c|d}}!", []string{"a!", "b c!", "b d!"}},
+		{"deeply nested", "{x{1|{2|3}}|y}", []string{"x1", "x2", "x3", "y"}},
+		{"action kept", "Hi {{.FirstName}}", []string{"Hi {{.FirstName}}"}},
+		{"action in option", "{Hi {{.FirstName}}|Hello}", []string{"Hello", "Hi {{.FirstName}}"}},
+		{"action first in group", "{{{.FirstName}}|there}", []string{"there", "{{.FirstName}}"}},
+		{"action last in group", "{there|{{.FirstName}}}", []string{"there", "{{.FirstName}}"}},
+		{"bar inside action", "{a|{{if .X}}b{{else}}c{{end}}}", []string{"a", "{{if .X}}b{{else}}c{{end}}"}},
+		{"action braces do not close", "{{.A}} {x|y}", []string{"{{.A}} x", "{{.A}} y"}},
+		{"unclosed group", "{a|b", []string{"{a|b"}},
+		{"unclosed action", "{{.A", []string{"{{.A"}},
+	}
+	for _, tc := range tests {
+		if got := spins(tc.src); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: Spin(%q) gives %q, want %q", tc.name, tc.src, got, tc.want)
+		}
+	}
+}
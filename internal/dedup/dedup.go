@@ -0,0 +1,167 @@
+package dedup
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Status is what a Claim found for an ID.
+type Status int
+
+const (
+	// Claimed means the caller now holds the processing lease.
+	Claimed Status = iota
+	// Leased means another claim holds the lease and has not finished.
+	Leased
+	// Processed means the ID was marked done within the TTL.
+	Processed
+)
+
+// Store remembers which event IDs are being or have been processed, so a
+// redelivered event is recognised and not processed twice. IDs are scoped by
+// tenant; an empty tenant is service-wide.
+type Store interface {
+	// Claim takes a short processing lease on the ID unless it is already
+	// leased or processed.
+	Claim(ctx context.Context, tenantID, id string) (Status, error)
+	// Done marks the ID processed, which is remembered for the TTL.
+	Done(ctx context.Context, tenantID, id string) error
+	// Release drops the lease so that a failed event can be processed again.
+	Release(ctx context.Context, tenantID, id string) error
+}
+
+// NewStore returns a Redis store for redis:// and rediss:// URLs and a
+// process-local one for memory:// or an empty URL. Leases expire after
+// lease, so an event whose worker died can be claimed again, and processed
+// markers after ttl.
+func NewStore(storeURL string, lease, ttl time.Duration) (Store, error) {
+	if lease <= 0 || ttl <= 0 {
+		return nil, fmt.Errorf("dedup lease and ttl must be positive, got %s and %s", lease, ttl)
+	}
+	if storeURL == "" {
+		return NewMemoryStore(lease, ttl), nil
+	}
+	u, err := url.Parse(storeURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "redis", "rediss":
+		opts, err := redis.ParseURL(storeURL)
+		if err != nil {
+			return nil, err
+		}
+		return &redisStore{rdb: redis.NewClient(opts), lease: lease, ttl: ttl}, nil
+	case "memory":
+		return NewMemoryStore(lease, ttl), nil
+	}
+	return nil, fmt.Errorf("unsupported dedup store URL scheme %q", u.Scheme)
+}
+
+// Values held under an ID's key.
+const (
+	leaseValue = "processing"
+	doneValue  = "done"
+)
+
+type redisStore struct {
+	rdb        *redis.Client
+	lease, ttl time.Duration
+}
+
+func key(tenantID, id string) string { return "event:" + tenantID + ":" + id }
+
+// claim sets the lease if the key is free and otherwise returns what holds it.
+var claim = redis.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return ARGV[1]
+end
+return redis.call("GET", KEYS[1])`)
+
+func (s *redisStore) Claim(ctx context.Context, tenantID, id string) (Status, error) {
+	v, err := claim.Run(ctx, s.rdb, []string{key(tenantID, id)}, leaseValue, s.lease.Milliseconds()).Text()
+	switch {
+	case err == redis.Nil:
+		// The holder expired between SET and GET; look again later.
+		return Leased, nil
+	case err != nil:
+		return 0, err
+	case v == doneValue:
+		return Processed, nil
+	case v == leaseValue:
+		return Leased, nil
+	}
+	return Claimed, nil
+}
+
+func (s *redisStore) Done(ctx context.Context, tenantID, id string) error {
+	return s.rdb.Set(ctx, key(tenantID, id), doneValue, s.ttl).Err()
+}
+
+func (s *redisStore) Release(ctx context.Context, tenantID, id string) error {
+	return s.rdb.Del(ctx, key(tenantID, id)).Err()
+}
+
+type memoryStore struct {
+	lease, ttl time.Duration
+
+	mu     sync.Mutex
+	claims map[string]memoryClaim
+	swept  time.Time
+}
+
+type memoryClaim struct {
+	done    bool
+	expires time.Time
+}
+
+// sweepEvery bounds how often expired claims are dropped from memory.
+const sweepEvery = time.Minute
+
+// NewMemoryStore keeps claims in process memory. It only catches duplicates
+// delivered to the same replica.
+func NewMemoryStore(lease, ttl time.Duration) Store {
+	return &memoryStore{lease: lease, ttl: ttl, claims: map[string]memoryClaim{}}
+}
+
+func (s *memoryStore) Claim(_ context.Context, tenantID, id string) (Status, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.swept) >= sweepEvery {
+		for k, c := range s.claims {
+			if !now.Before(c.expires) {
+				delete(s.claims, k)
+			}
+		}
+		s.swept = now
+	}
+	k := key(tenantID, id)
+	if c, ok := s.claims[k]; ok && now.Before(c.expires) {
+		if c.done {
+			return Processed, nil
+		}
+		return Leased, nil
+	}
+	s.claims[k] = memoryClaim{expires: now.Add(s.lease)}
+	return Claimed, nil
+}
+
+func (s *memoryStore) Done(_ context.Context, tenantID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.claims[key(tenantID, id)] = memoryClaim{done: true, expires: time.Now().Add(s.ttl)}
+	return nil
+}
+
+func (s *memoryStore) Release(_ context.Context, tenantID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.claims, key(tenantID, id))
+	return nil
+}
@@ -0,0 +1,179 @@
+package delay
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/ilivestrong/email_warmup_service/internal/queue/events"
+)
+
+// Store holds events until their SendAt time.
+type Store interface {
+	Schedule(ctx context.Context, ev *events.SendEmailEvent) error
+	// Due claims up to limit events whose time has come. A claimed event is
+	// handed out again after lease unless it is acknowledged with Done.
+	Due(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Item, error)
+	Done(ctx context.Context, it Item) error
+}
+
+// Item is a claimed event; Ref identifies it to Done.
+type Item struct {
+	Event *events.SendEmailEvent
+	Ref   string
+}
+
+const (
+	pollBatch = 100
+	// claimLease is how long a claimed event may take to be republished
+	// before another poller picks it up.
+	claimLease = time.Minute
+)
+
+// Run polls the store every interval until ctx is done and publishes due
+// events, which then flow through normal processing.
+func Run(ctx context.Context, s Store, interval time.Duration, publish func(context.Context, *events.SendEmailEvent) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		items, err := s.Due(ctx, time.Now(), claimLease, pollBatch)
+		if err != nil {
+			log.Printf("failed to read delayed events: %v", err)
+			continue
+		}
+		for _, it := range items {
+			if err := publish(ctx, it.Event); err != nil {
+				log.Printf("failed to publish delayed event %s: %v", it.Event.EventID, err)
+				continue
+			}
+			if err := s.Done(ctx, it); err != nil {
+				log.Printf("failed to remove delayed event %s: %v", it.Event.EventID, err)
+			}
+		}
+	}
+}
+
+// NewRedisStore keeps delayed events in the sorted set "delayed_events",
+// scored by their due time in unix milliseconds, so they survive restarts
+// and are shared by every replica.
+func NewRedisStore(redisURL string) (Store, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	return &redisStore{rdb: redis.NewClient(opts)}, nil
+}
+
+const delayedKey = "delayed_events"
+
+// claim returns due members and pushes their score back by the lease, so
+// concurrent pollers do not hand out the same event.
+var claim = redis.NewScript(`
+local due = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[3])
+for _, m in ipairs(due) do
+	redis.call("ZADD", KEYS[1], "XX", ARGV[2], m)
+end
+return due`)
+
+type redisStore struct {
+	rdb *redis.Client
+}
+
+func (s *redisStore) Schedule(ctx context.Context, ev *events.SendEmailEvent) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return s.rdb.ZAdd(ctx, delayedKey, &redis.Z{Score: float64(ev.SendAt.UnixMilli()), Member: string(b)}).Err()
+}
+
+func (s *redisStore) Due(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Item, error) {
+	ms := now.UnixMilli()
+	members, err := claim.Run(ctx, s.rdb, []string{delayedKey},
+		strconv.FormatInt(ms, 10), strconv.FormatInt(ms+lease.Milliseconds(), 10), limit).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+	var out []Item
+	for _, m := range members {
+		ev := new(events.SendEmailEvent)
+		if err := json.Unmarshal([]byte(m), ev); err != nil {
+			log.Printf("dropping unreadable delayed event: %v", err)
+			s.rdb.ZRem(ctx, delayedKey, m)
+			continue
+		}
+		out = append(out, Item{Event: ev, Ref: m})
+	}
+	return out, nil
+}
+
+func (s *redisStore) Done(ctx context.Context, it Item) error {
+	return s.rdb.ZRem(ctx, delayedKey, it.Ref).Err()
+}
+
+// NewMemoryStore keeps delayed events in process memory. They are lost on
+// restart.
+func NewMemoryStore() Store {
+	return &memoryStore{items: map[string]memoryItem{}}
+}
+
+type memoryItem struct {
+	ev  *events.SendEmailEvent
+	due time.Time
+}
+
+type memoryStore struct {
+	mu    sync.Mutex
+	items map[string]memoryItem // keyed by the event's JSON, like the sorted set
+}
+
+func (s *memoryStore) Schedule(_ context.Context, ev *events.SendEmailEvent) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[string(b)] = memoryItem{ev: ev, due: *ev.SendAt}
+	return nil
+}
+
+func (s *memoryStore) Due(_ context.Context, now time.Time, lease time.Duration, limit int) ([]Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var refs []string
+	for ref, it := range s.items {
+		if !it.due.After(now) {
+			refs = append(refs, ref)
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool { return s.items[refs[i]].due.Before(s.items[refs[j]].due) })
+	if len(refs) > limit {
+		refs = refs[:limit]
+	}
+	out := make([]Item, 0, len(refs))
+	for _, ref := range refs {
+		it := s.items[ref]
+		it.due = now.Add(lease)
+		s.items[ref] = it
+		out = append(out, Item{Event: it.ev, Ref: ref})
+	}
+	return out, nil
+}
+
+func (s *memoryStore) Done(_ context.Context, it Item) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.items, it.Ref)
+	return nil
+}
@@ -0,0 +1,186 @@
+// Package historytest is the conformance suite every history.Store
+// implementation must pass.
+package historytest
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ilivestrong/email_warmup_service/internal/history"
+	"github.com/ilivestrong/email_warmup_service/internal/inbox"
+	"github.com/ilivestrong/email_warmup_service/internal/quota"
+)
+
+// t0 starts an hour bucket.
+var t0 = time.Date(2025, 1, 2, 10, 0, 0, 0, time.UTC)
+
+// Run runs the suite. newStore must return an empty store each call.
+func Run(t *testing.T, newStore func(t *testing.T) history.Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s history.Store)
+	}{
+		{"RoundTrip", testRoundTrip},
+		{"SendsFilters", testSendsFilters},
+		{"SeriesLeavesFollowupsOut", testSeries},
+		{"Purge", testPurge},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newStore(t)
+			t.Cleanup(func() { s.Close() })
+			tc.fn(t, s)
+		})
+	}
+}
+
+func save(t *testing.T, s history.Store, recs ...history.Record) {
+	t.Helper()
+	for _, rec := range recs {
+		if err := s.Save(context.Background(), rec); err != nil {
+			t.Fatalf("Save(%s): %v", rec.EventID, err)
+		}
+	}
+}
+
+func send(id, sender string, p inbox.Provider, at time.Duration, score float64, outs ...quota.Outcome) history.Record {
+	return history.Record{
+		EventID: id, TenantID: "t1", Sender: sender, Recipient: "seed@example.com", Provider: p,
+		Attempts: 1, Outcomes: outs, Score: score, ProcessedAt: t0.Add(at),
+	}
+}
+
+func ids(recs []history.Record) []string {
+	var out []string
+	for _, r := range recs {
+		out = append(out, r.EventID)
+	}
+	return out
+}
+
+func testRoundTrip(t *testing.T, s history.Store) {
+	want := history.Record{
+		EventID:     "e1",
+		TenantID:    "t1",
+		Sender:      "alice@acme.com",
+		Recipient:   "seed@example.com",
+		Provider:    inbox.Gmail,
+		MessageID:   "<e1@acme.com>",
+		Attempts:    2,
+		Outcomes:    []quota.Outcome{quota.Spam, quota.Rescued},
+		Score:       1.5,
+		Followup:    true,
+		Timeline:    []history.Step{{At: t0, Status: "received"}, {At: t0.Add(time.Second), Status: "rescued", Detail: "x"}},
+		ProcessedAt: t0,
+	}
+	save(t, s, want)
+	got, err := s.Sends(context.Background(), history.Query{TenantID: "t1", From: t0, To: t0.Add(time.Hour)}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("Sends returned %d records, want 1", len(got))
+	}
+	r := got[0]
+	if r.EventID != want.EventID || r.Sender != want.Sender || r.Recipient != want.Recipient ||
+		r.Provider != want.Provider || r.MessageID != want.MessageID || r.Attempts != want.Attempts ||
+		r.Score != want.Score || !r.Followup || !r.ProcessedAt.Equal(want.ProcessedAt) ||
+		!slices.Equal(r.Outcomes, want.Outcomes) {
+		t.Fatalf("record = %+v, want %+v", r, want)
+	}
+	if len(r.Timeline) != 2 || r.Timeline[1].Status != "rescued" || r.Timeline[1].Detail != "x" || !r.Timeline[1].At.Equal(want.Timeline[1].At) {
+		t.Fatalf("timeline = %+v, want %+v", r.Timeline, want.Timeline)
+	}
+}
+
+func testSendsFilters(t *testing.T, s history.Store) {
+	ctx := context.Background()
+	other := send("other-tenant", "alice@acme.com", inbox.Gmail, time.Minute, 1)
+	other.TenantID = "t2"
+	save(t, s,
+		send("a1", "alice@acme.com", inbox.Gmail, 0, 1),
+		send("b1", "bob@acme.com", inbox.Yahoo, 10*time.Minute, 1),
+		send("a2", "alice@acme.com", inbox.Yahoo, 20*time.Minute, 1),
+		send("a3", "alice@acme.com", inbox.Gmail, time.Hour, 1), // at To, excluded
+		send("c1", "Carol@Acme.com", inbox.Gmail, 30*time.Minute, 1),
+		other,
+	)
+	window := history.Query{TenantID: "t1", From: t0, To: t0.Add(time.Hour)}
+	tests := []struct {
+		name  string
+		q     func(q history.Query) history.Query
+		limit int
+		want  []string
+	}{
+		{"newest first", func(q history.Query) history.Query { return q }, 10, []string{"c1", "a2", "b1", "a1"}},
+		{"limit", func(q history.Query) history.Query { return q }, 2, []string{"c1", "a2"}},
+		{"sender saved in mixed case", func(q history.Query) history.Query { q.Sender = "carol@acme.com"; return q }, 10, []string{"c1"}},
+		{"sender", func(q history.Query) history.Query { q.Sender = "Alice@Acme.com"; return q }, 10, []string{"a2", "a1"}},
+		{"provider", func(q history.Query) history.Query { q.Provider = inbox.Yahoo; return q }, 10, []string{"a2", "b1"}},
+		{"from", func(q history.Query) history.Query { q.From = t0.Add(5 * time.Minute); return q }, 10, []string{"c1", "a2", "b1"}},
+	}
+	for _, tc := range tests {
+		got, err := s.Sends(ctx, tc.q(window), tc.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(ids(got), tc.want) {
+			t.Errorf("%s: Sends = %v, want %v", tc.name, ids(got), tc.want)
+		}
+	}
+}
+
+func testSeries(t *testing.T, s history.Store) {
+	rescue := send("r1", "alice@acme.com", inbox.Gmail, 20*time.Minute, 1, quota.Spam, quota.Rescued)
+	rescue.Followup = true
+	reply := send("p1", "alice@acme.com", inbox.Gmail, 2*time.Hour, 2, quota.Replied)
+	reply.Followup = true
+	save(t, s,
+		send("s1", "alice@acme.com", inbox.Gmail, 0, 3, quota.Delivered, quota.Opened),
+		send("s2", "alice@acme.com", inbox.Gmail, 10*time.Minute, -2, quota.HardBounce),
+		rescue,
+		send("s3", "alice@acme.com", inbox.Gmail, time.Hour+time.Minute, 1, quota.Delivered),
+		reply, // a bucket of followups alone
+	)
+	got, err := s.Series(context.Background(), history.Query{TenantID: "t1", From: t0, To: t0.Add(3 * time.Hour)}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []history.Point{
+		{Start: t0, Sends: 2, Delivered: 1, Bounced: 1, Opened: 1, Spam: 1, Rescued: 1, AvgScore: 1},
+		{Start: t0.Add(time.Hour), Sends: 1, Delivered: 1, AvgScore: 1},
+		{Start: t0.Add(2 * time.Hour), Replied: 1},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Series = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if !got[i].Start.Equal(want[i].Start) || got[i].Sends != want[i].Sends || got[i].Delivered != want[i].Delivered ||
+			got[i].Bounced != want[i].Bounced || got[i].Opened != want[i].Opened || got[i].Replied != want[i].Replied ||
+			got[i].Spam != want[i].Spam || got[i].Rescued != want[i].Rescued || got[i].AvgScore != want[i].AvgScore {
+			t.Errorf("point %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func testPurge(t *testing.T, s history.Store) {
+	ctx := context.Background()
+	save(t, s,
+		send("old1", "alice@acme.com", inbox.Gmail, -48*time.Hour, 1),
+		send("old2", "alice@acme.com", inbox.Gmail, -25*time.Hour, 1),
+		send("new", "alice@acme.com", inbox.Gmail, 0, 1),
+	)
+	n, err := s.Purge(ctx, t0.Add(-24*time.Hour))
+	if err != nil || n != 2 {
+		t.Fatalf("Purge = %d, %v; want 2, nil", n, err)
+	}
+	got, err := s.Sends(ctx, history.Query{TenantID: "t1", From: t0.Add(-72 * time.Hour), To: t0.Add(time.Hour)}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ids(got), []string{"new"}) {
+		t.Fatalf("Sends after purge = %v, want [new]", ids(got))
+	}
+}
@@ -0,0 +1,260 @@
+package history
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ilivestrong/email_warmup_service/internal/inbox"
+	"github.com/ilivestrong/email_warmup_service/internal/quota"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// dialect holds what differs between the SQL databases history supports.
+// Queries are written with ? placeholders and rebound for numbered ones.
+type dialect struct {
+	driver   string
+	schema   string
+	numbered bool
+	// migrations bring tables created by earlier versions up to date. An
+	// error matching alreadyDone means the migration has been applied.
+	migrations  []string
+	alreadyDone string
+}
+
+const columns = `event_id, tenant_id, sender, recipient, provider, message_id, attempts,
+	outcomes, delivered, bounced, opened, replied, spam, rescued, score, followup, timeline, processed_at`
+
+var sqlite = dialect{driver: "sqlite", schema: `
+CREATE TABLE IF NOT EXISTS sends (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	event_id     TEXT NOT NULL,
+	tenant_id    TEXT NOT NULL,
+	sender       TEXT NOT NULL,
+	recipient    TEXT NOT NULL,
+	provider     TEXT NOT NULL,
+	message_id   TEXT NOT NULL,
+	attempts     INTEGER NOT NULL,
+	outcomes     TEXT NOT NULL,
+	delivered    INTEGER NOT NULL,
+	bounced      INTEGER NOT NULL,
+	opened       INTEGER NOT NULL,
+	replied      INTEGER NOT NULL,
+	spam         INTEGER NOT NULL,
+	rescued      INTEGER NOT NULL,
+	score        REAL NOT NULL,
+	followup     INTEGER NOT NULL DEFAULT 0,
+	timeline     TEXT NOT NULL,
+	processed_at INTEGER NOT NULL -- unix milliseconds
+);
+CREATE INDEX IF NOT EXISTS sends_tenant_time ON sends (tenant_id, processed_at);
+CREATE INDEX IF NOT EXISTS sends_time ON sends (processed_at);
+`,
+	migrations:  []string{`ALTER TABLE sends ADD COLUMN followup INTEGER NOT NULL DEFAULT 0`},
+	alreadyDone: "duplicate column",
+}
+
+var postgres = dialect{driver: "pgx", numbered: true, schema: `
+CREATE TABLE IF NOT EXISTS sends (
+	id           BIGSERIAL PRIMARY KEY,
+	event_id     TEXT NOT NULL,
+	tenant_id    TEXT NOT NULL,
+	sender       TEXT NOT NULL,
+	recipient    TEXT NOT NULL,
+	provider     TEXT NOT NULL,
+	message_id   TEXT NOT NULL,
+	attempts     INTEGER NOT NULL,
+	outcomes     TEXT NOT NULL,
+	delivered    INTEGER NOT NULL,
+	bounced      INTEGER NOT NULL,
+	opened       INTEGER NOT NULL,
+	replied      INTEGER NOT NULL,
+	spam         INTEGER NOT NULL,
+	rescued      INTEGER NOT NULL,
+	score        DOUBLE PRECISION NOT NULL,
+	followup     INTEGER NOT NULL DEFAULT 0,
+	timeline     TEXT NOT NULL,
+	processed_at BIGINT NOT NULL -- unix milliseconds
+);
+CREATE INDEX IF NOT EXISTS sends_tenant_time ON sends (tenant_id, processed_at);
+CREATE INDEX IF NOT EXISTS sends_time ON sends (processed_at);
+`,
+	migrations: []string{`ALTER TABLE sends ADD COLUMN IF NOT EXISTS followup INTEGER NOT NULL DEFAULT 0`},
+}
+
+func (d dialect) rebind(q string) string {
+	if !d.numbered {
+		return q
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range q {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+type sqlStore struct {
+	db *sql.DB
+	d  dialect
+}
+
+func openSQL(d dialect, dsn string) (Store, error) {
+	db, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if d.driver == "sqlite" {
+		db.SetMaxOpenConns(1)
+	}
+	for _, stmt := range strings.Split(d.schema, ";") {
+		if strings.TrimSpace(stmt) == "" {
+			continue
+		}
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	for _, stmt := range d.migrations {
+		if _, err := db.Exec(stmt); err != nil && (d.alreadyDone == "" || !strings.Contains(err.Error(), d.alreadyDone)) {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &sqlStore{db: db, d: d}, nil
+}
+
+func flag(rec Record, outcomes ...quota.Outcome) int {
+	for _, o := range outcomes {
+		for _, have := range rec.Outcomes {
+			if have == o {
+				return 1
+			}
+		}
+	}
+	return 0
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Save stores the sender in lower case, as Query.Sender is matched.
+func (s *sqlStore) Save(ctx context.Context, rec Record) error {
+	outcomes, err := json.Marshal(rec.Outcomes)
+	if err != nil {
+		return err
+	}
+	timeline, err := json.Marshal(rec.Timeline)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, s.d.rebind(`INSERT INTO sends (`+columns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		rec.EventID, rec.TenantID, strings.ToLower(rec.Sender), rec.Recipient, string(rec.Provider), rec.MessageID, rec.Attempts,
+		string(outcomes),
+		flag(rec, quota.Delivered), flag(rec, quota.SoftBounce, quota.HardBounce), flag(rec, quota.Opened),
+		flag(rec, quota.Replied), flag(rec, quota.Spam), flag(rec, quota.Rescued),
+		rec.Score, boolInt(rec.Followup), string(timeline), rec.ProcessedAt.UnixMilli())
+	return err
+}
+
+// where renders the filter of q and its arguments.
+func where(q Query) (string, []any) {
+	clause := ` WHERE tenant_id = ? AND processed_at >= ? AND processed_at < ?`
+	args := []any{q.TenantID, q.From.UnixMilli(), q.To.UnixMilli()}
+	if q.Sender != "" {
+		clause += ` AND sender = ?`
+		args = append(args, strings.ToLower(q.Sender))
+	}
+	if q.Provider != "" {
+		clause += ` AND provider = ?`
+		args = append(args, string(q.Provider))
+	}
+	return clause, args
+}
+
+func (s *sqlStore) Sends(ctx context.Context, q Query, limit int) ([]Record, error) {
+	clause, args := where(q)
+	rows, err := s.db.QueryContext(ctx, s.d.rebind(`SELECT event_id, tenant_id, sender, recipient, provider,
+		message_id, attempts, outcomes, score, followup, timeline, processed_at FROM sends`+clause+`
+		ORDER BY processed_at DESC, id DESC LIMIT ?`), append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Record
+	for rows.Next() {
+		var rec Record
+		var provider, outcomes, timeline string
+		var at int64
+		var followup int
+		err := rows.Scan(&rec.EventID, &rec.TenantID, &rec.Sender, &rec.Recipient, &provider,
+			&rec.MessageID, &rec.Attempts, &outcomes, &rec.Score, &followup, &timeline, &at)
+		if err != nil {
+			return nil, err
+		}
+		rec.Followup = followup != 0
+		rec.Provider = inbox.Provider(provider)
+		rec.ProcessedAt = time.UnixMilli(at).UTC()
+		if err := json.Unmarshal([]byte(outcomes), &rec.Outcomes); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(timeline), &rec.Timeline); err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqlStore) Series(ctx context.Context, q Query, bucket time.Duration) ([]Point, error) {
+	ms := bucket.Milliseconds()
+	if ms <= 0 {
+		ms = time.Hour.Milliseconds()
+	}
+	clause, args := where(q)
+	rows, err := s.db.QueryContext(ctx, s.d.rebind(`SELECT (processed_at / ?) * ? AS bucket, SUM(1 - followup),
+		SUM(delivered), SUM(bounced), SUM(opened), SUM(replied), SUM(spam), SUM(rescued),
+		COALESCE(SUM(score) / NULLIF(SUM(1 - followup), 0), 0)
+		FROM sends`+clause+` GROUP BY bucket ORDER BY bucket`), append([]any{ms, ms}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Point
+	for rows.Next() {
+		var p Point
+		var start int64
+		err := rows.Scan(&start, &p.Sends, &p.Delivered, &p.Bounced, &p.Opened, &p.Replied, &p.Spam, &p.Rescued, &p.AvgScore)
+		if err != nil {
+			return nil, err
+		}
+		p.Start = time.UnixMilli(start).UTC()
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqlStore) Purge(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, s.d.rebind(`DELETE FROM sends WHERE processed_at < ?`), before.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *sqlStore) Close() error { return s.db.Close() }
@@ -0,0 +1,41 @@
+package history_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ilivestrong/email_warmup_service/internal/history"
+	"github.com/ilivestrong/email_warmup_service/internal/history/historytest"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	historytest.Run(t, func(t *testing.T) history.Store {
+		s, err := history.NewStore("sqlite://" + filepath.Join(t.TempDir(), "history.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+// TestPostgresStore runs against HISTORY_TEST_POSTGRES_URL and empties its
+// sends table before every case, so it must point at a scratch database.
+func TestPostgresStore(t *testing.T) {
+	url := os.Getenv("HISTORY_TEST_POSTGRES_URL")
+	if url == "" {
+		t.Skip("HISTORY_TEST_POSTGRES_URL not set")
+	}
+	historytest.Run(t, func(t *testing.T) history.Store {
+		s, err := history.NewStore(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Purge(context.Background(), time.Now().AddDate(100, 0, 0)); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
@@ -0,0 +1,113 @@
+package inbox
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Provider is the mailbox provider hosting a recipient's inbox. Inbox
+// providers judge senders independently, so reputation is tracked per
+// Provider.
+type Provider string
+
+const (
+	Gmail   Provider = "gmail"
+	Outlook Provider = "outlook"
+	Yahoo   Provider = "yahoo"
+	Other   Provider = "other"
+)
+
+// Providers lists every provider reputation is broken down by.
+var Providers = []Provider{Gmail, Outlook, Yahoo, Other}
+
+var knownDomains = map[string]Provider{
+	"gmail.com":      Gmail,
+	"googlemail.com": Gmail,
+	"outlook.com":    Outlook,
+	"hotmail.com":    Outlook,
+	"live.com":       Outlook,
+	"msn.com":        Outlook,
+	"yahoo.com":      Yahoo,
+	"ymail.com":      Yahoo,
+	"rocketmail.com": Yahoo,
+	"aol.com":        Yahoo,
+}
+
+// mxSuffixes recognise custom domains hosted by a provider, e.g. Google
+// Workspace or Microsoft 365 tenants.
+var mxSuffixes = []struct {
+	suffix   string
+	provider Provider
+}{
+	{"google.com.", Gmail},
+	{"googlemail.com.", Gmail},
+	{"outlook.com.", Outlook},
+	{"hotmail.com.", Outlook},
+	{"yahoodns.net.", Yahoo},
+}
+
+// MXResolver is satisfied by *net.Resolver.
+type MXResolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
+// Detector maps recipient addresses to their Provider, first by well-known
+// consumer domains and then by the domain's MX hosts. Results are cached per
+// domain for the life of the Detector.
+type Detector struct {
+	mx    MXResolver
+	mu    sync.RWMutex
+	cache map[string]Provider
+}
+
+func NewDetector(mx MXResolver) *Detector {
+	return &Detector{mx: mx, cache: map[string]Provider{}}
+}
+
+func (d *Detector) Detect(ctx context.Context, address string) Provider {
+	i := strings.LastIndex(address, "@")
+	if i < 0 {
+		return Other
+	}
+	domain := strings.ToLower(strings.TrimSuffix(address[i+1:], ">"))
+	if p, ok := knownDomains[domain]; ok {
+		return p
+	}
+
+	d.mu.RLock()
+	p, ok := d.cache[domain]
+	d.mu.RUnlock()
+	if ok {
+		return p
+	}
+
+	records, err := d.mx.LookupMX(ctx, domain)
+	if err != nil {
+		// Lookup failures are not cached so a transient DNS error does not
+		// pin the domain to Other.
+		return Other
+	}
+	p = fromMX(records)
+
+	d.mu.Lock()
+	d.cache[domain] = p
+	d.mu.Unlock()
+	return p
+}
+
+func fromMX(records []*net.MX) Provider {
+	for _, mx := range records {
+		host := strings.ToLower(mx.Host)
+		if !strings.HasSuffix(host, ".") {
+			host += "."
+		}
+		for _, s := range mxSuffixes {
+			if strings.HasSuffix(host, "."+s.suffix) {
+				return s.provider
+			}
+		}
+	}
+	return Other
+}
@@ -0,0 +1,188 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var ErrNotLeader = errors.New("not the leader")
+
+// acquire takes the lease if it is free and starts the next term.
+var acquire = redis.NewScript(`
+if not redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return 0
+end
+local term = redis.call("INCR", KEYS[2])
+redis.call("SET", KEYS[1], ARGV[1] .. ":" .. term, "PX", ARGV[2])
+return term`)
+
+// renew extends the lease only while it still holds our value.
+var renew = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+var release = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// Elector holds a Redis lease (SET NX PX) so that only one instance leads.
+// Each acquisition starts a new term, numbered in the lease value, so a
+// leader that was paused past its lease cannot renew or release its
+// successor's lease; it sees the newer term and stands down. Writes that must
+// not be made by a stale leader carry the term as a fencing token, see
+// quota.Fence, and a leader also confirms its lease before such work.
+type Elector struct {
+	rdb        *redis.Client
+	key, terms string
+	id         string
+	ttl        time.Duration
+	log        *slog.Logger
+
+	mu   sync.RWMutex
+	term int64 // 0 while not leading
+}
+
+func New(redisURL, name, instanceID string, ttl time.Duration, log *slog.Logger) (*Elector, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("leader lease ttl must be positive, got %s", ttl)
+	}
+	return &Elector{
+		rdb:   redis.NewClient(opts),
+		key:   "leader:" + name,
+		terms: "leader:" + name + ":term",
+		id:    instanceID,
+		ttl:   ttl,
+		log:   log.With(slog.String("lease", name), slog.String("instance_id", instanceID)),
+	}, nil
+}
+
+// Run campaigns for and renews the lease every third of its TTL until ctx is
+// done, then releases it.
+func (e *Elector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+	for {
+		e.tick(ctx)
+		select {
+		case <-ctx.Done():
+			e.resign()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *Elector) tick(ctx context.Context) {
+	ttl := strconv.FormatInt(e.ttl.Milliseconds(), 10)
+	e.mu.RLock()
+	term := e.term
+	e.mu.RUnlock()
+
+	if term != 0 {
+		ok, err := renew.Run(ctx, e.rdb, []string{e.key}, e.value(term), ttl).Int()
+		if err == nil && ok == 1 {
+			return
+		}
+		e.setTerm(0)
+		e.log.Warn("LEADERSHIP_LOST", slog.Int64("term", term), slog.Any("error", err))
+		return
+	}
+
+	term, err := acquire.Run(ctx, e.rdb, []string{e.key, e.terms}, e.id, ttl).Int64()
+	if err != nil {
+		e.log.Error("LEADERSHIP_CAMPAIGN_FAILED", slog.Any("error", err))
+		return
+	}
+	if term == 0 {
+		return
+	}
+	e.setTerm(term)
+	e.log.Info("LEADERSHIP_ACQUIRED", slog.Int64("term", term))
+}
+
+func (e *Elector) resign() {
+	e.mu.RLock()
+	term := e.term
+	e.mu.RUnlock()
+	if term == 0 {
+		return
+	}
+	e.setTerm(0)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := release.Run(ctx, e.rdb, []string{e.key}, e.value(term)).Err(); err != nil {
+		e.log.Warn("LEADERSHIP_RELEASE_FAILED", slog.Any("error", err))
+		return
+	}
+	e.log.Info("LEADERSHIP_RELEASED", slog.Int64("term", term))
+}
+
+func (e *Elector) setTerm(term int64) {
+	e.mu.Lock()
+	e.term = term
+	e.mu.Unlock()
+}
+
+func (e *Elector) value(term int64) string {
+	return fmt.Sprintf("%s:%d", e.id, term)
+}
+
+// Term returns the current term, or 0 while not leading.
+func (e *Elector) Term() int64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.term
+}
+
+func (e *Elector) IsLeader() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.term != 0
+}
+
+// Confirm checks against Redis that this instance still holds the lease in
+// its current term. Any failure, including a Redis error, gives up
+// leadership until the lease is won again, since it cannot be proven.
+func (e *Elector) Confirm(ctx context.Context) error {
+	e.mu.RLock()
+	term := e.term
+	e.mu.RUnlock()
+	if term == 0 {
+		return ErrNotLeader
+	}
+	holder, err := e.rdb.Get(ctx, e.key).Result()
+	if err != nil && err != redis.Nil {
+		e.setTerm(0)
+		e.log.Warn("LEADERSHIP_LOST", slog.Int64("term", term), slog.Any("error", err))
+		return err
+	}
+	if holder != e.value(term) {
+		e.setTerm(0)
+		e.log.Warn("LEADERSHIP_LOST", slog.Int64("term", term), slog.String("holder", holder))
+		return ErrNotLeader
+	}
+	return nil
+}
+
+// Solo always leads, in a single term. It is for single-instance deployments
+// without Redis.
+type Solo struct{}
+
+func (Solo) IsLeader() bool                { return true }
+func (Solo) Term() int64                   { return 1 }
+func (Solo) Confirm(context.Context) error { return nil }
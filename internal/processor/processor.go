@@ -2,17 +2,29 @@ package processor
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
 	"log/slog"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/ilivestrong/email_warmup_service/internal/clock"
 	"github.com/ilivestrong/email_warmup_service/internal/config"
+	"github.com/ilivestrong/email_warmup_service/internal/dedup"
+	"github.com/ilivestrong/email_warmup_service/internal/delay"
+	"github.com/ilivestrong/email_warmup_service/internal/history"
+	"github.com/ilivestrong/email_warmup_service/internal/inbox"
 	"github.com/ilivestrong/email_warmup_service/internal/providers"
 	"github.com/ilivestrong/email_warmup_service/internal/queue"
+	"github.com/ilivestrong/email_warmup_service/internal/queue/events"
 	"github.com/ilivestrong/email_warmup_service/internal/quota"
 	"github.com/ilivestrong/email_warmup_service/internal/resolver"
+	"github.com/ilivestrong/email_warmup_service/internal/scoring"
+	"github.com/ilivestrong/email_warmup_service/internal/seeds"
+	"github.com/ilivestrong/email_warmup_service/internal/tracking"
 	"github.com/ilivestrong/email_warmup_service/internal/validator"
 )
 
@@ -23,71 +35,239 @@ type Processor struct {
 	qc            queue.Client
 	rp            config.RetryPolicy
 	emailResolver resolver.Resolver
+	tc            *clock.Tenants
+	inboxes       *inbox.Detector
+	scores        *scoring.Model
+	history       history.Store
+	dedup         dedup.Store
+	delayed       delay.Store
+	replies       *seeds.Responder
+	rescuer       *seeds.Rescuer
+	tags          *tracking.Tagger
 	log           *slog.Logger
+	next          atomic.Uint32 // rotates sender selection
 }
 
-func New(qs quota.Store, v *validator.Validator, pf *providers.Factory, er resolver.Resolver, qc queue.Client, rp config.RetryPolicy, log *slog.Logger) *Processor {
-	return &Processor{qs, v, pf, qc, rp, er, log}
+// Deps are the services a Processor works with.
+type Deps struct {
+	Quota     quota.Store
+	Validator *validator.Validator
+	Providers *providers.Factory
+	Resolver  resolver.Resolver
+	Queue     queue.Client
+	Retry     config.RetryPolicy
+	Clock     *clock.Tenants
+	Inboxes   *inbox.Detector
+	Scores    *scoring.Model
+	History   history.Store
+	Dedup     dedup.Store
+	Delayed   delay.Store
+	Replies   *seeds.Responder
+	Rescuer   *seeds.Rescuer
+	Tags      *tracking.Tagger
+	Log       *slog.Logger
+}
+
+func New(d Deps) *Processor {
+	return &Processor{
+		qs: d.Quota, v: d.Validator, pf: d.Providers, qc: d.Queue, rp: d.Retry, emailResolver: d.Resolver,
+		tc: d.Clock, inboxes: d.Inboxes, scores: d.Scores, history: d.History, dedup: d.Dedup,
+		delayed: d.Delayed, replies: d.Replies, rescuer: d.Rescuer, tags: d.Tags, log: d.Log,
+	}
 }
 
 func (p *Processor) Start(ctx context.Context) error { return p.qc.Consume(ctx, p.handle) }
 
-func (p *Processor) handle(ctx context.Context, ev *queue.SendEmailEvent) error {
+func (p *Processor) handle(ctx context.Context, ev *queue.SendEmailEvent) (err error) {
 
+	eventID := ev.EventID
+	if eventID == "" {
+		eventID = uuid.New().String()
+	}
 	l := p.log.With(
 		slog.String("tenant_id", ev.TenantID),
-		slog.String("event_id", uuid.New().String()),
+		slog.String("event_id", eventID),
 		slog.String("to", ev.ToAddress),
 		slog.String("subject", ev.Subject),
+		slog.Int("priority", ev.Priority),
 	)
+	if ev.CorrelationID != "" {
+		l = l.With(slog.String("correlation_id", ev.CorrelationID))
+	}
 
 	l.Info("EVENT_RECEIVED")
 
-	if !p.v.IsValid(ev.ToAddress) {
-		fmt.Printf("[%s] is invalid, skipping...\n", ev.ToAddress)
+	// Early events are parked before their ID is claimed, so the copy
+	// published when they fall due is not taken for a duplicate.
+	if ev.SendAt != nil && ev.SendAt.After(time.Now()) {
+		if err := p.delayed.Schedule(ctx, ev); err != nil {
+			l.Error("DEFER_FAILED", slog.Any("error", err))
+			return err
+		}
+		l.Info("SEND_DEFERRED", slog.Time("send_at", *ev.SendAt))
 		return nil
 	}
-	l.Info("VALIDATION_PASSED")
 
-	now := time.Now().UTC()
-	r, _ := p.qs.GetRemainingQuota(ctx, ev.TenantID, now)
-	if r <= 0 {
-		p.qs.ResetQuota(ctx, ev.TenantID, now.Format("2006-01-02"), 100)
-		r = 100
-		l.Info("QUOTA_RESET", slog.Int("reset_to", 100))
+	// A failed event is parked and tried again rather than dropped. Its
+	// claim is released before this runs, so the retry is not taken for a
+	// duplicate.
+	defer func() {
+		if err == nil {
+			return
+		}
+		retryAt := time.Now().Add(failureRetry)
+		ev.SendAt = &retryAt
+		if derr := p.delayed.Schedule(context.WithoutCancel(ctx), ev); derr != nil {
+			l.Error("DEFER_FAILED", slog.Any("error", derr))
+			return
+		}
+		l.Warn("EVENT_RETRY_SCHEDULED", slog.Time("send_at", retryAt), slog.Any("error", err))
+		err = nil
+	}()
+
+	// Events without a producer ID cannot be recognised when redelivered.
+	if ev.EventID != "" {
+		st, err := p.dedup.Claim(ctx, ev.TenantID, ev.EventID)
+		if err != nil {
+			l.Error("DEDUP_CHECK_FAILED", slog.Any("error", err))
+			return err
+		}
+		switch st {
+		case dedup.Processed:
+			l.Warn("DUPLICATE_EVENT")
+			return nil
+		case dedup.Leased:
+			// Another worker is on it, or died on it. Look again later
+			// rather than drop it, so a dead worker's event is not lost
+			// once its lease expires.
+			sendAt := time.Now().Add(leaseRecheck)
+			ev.SendAt = &sendAt
+			if err := p.delayed.Schedule(ctx, ev); err != nil {
+				l.Error("DEFER_FAILED", slog.Any("error", err))
+				return err
+			}
+			l.Warn("EVENT_IN_PROGRESS", slog.Time("send_at", sendAt))
+			return nil
+		}
+		defer func() {
+			if err != nil {
+				_ = p.dedup.Release(context.WithoutCancel(ctx), ev.TenantID, ev.EventID)
+				return
+			}
+			p.markDone(context.WithoutCancel(ctx), l, ev)
+		}()
+	}
+	rec := history.Record{EventID: eventID, TenantID: ev.TenantID, Recipient: ev.ToAddress}
+	rec.Step("received", "")
+
+	switch ev.Kind {
+	case events.KindSeedReply:
+		return p.sendSeedReply(ctx, l, ev, rec)
+	case events.KindSeedRescue:
+		return p.rescueSeed(ctx, l, ev, rec)
+	}
+
+	verdict := p.v.Validate(ctx, ev.TenantID, ev.ToAddress)
+	vattrs := []any{
+		slog.String("status", string(verdict.Status)),
+		slog.String("reason", verdict.Reason),
+		slog.String("source", verdict.Source),
+	}
+	if verdict.Rejected() {
+		l.Warn("VALIDATION_FAILED", append(vattrs, slog.String("detail", verdict.Detail))...)
+		rec.Step("invalid", verdict.Source+": "+verdict.Reason)
+		p.saveHistory(ctx, l, rec)
+		rejection := validator.Rejection{EventID: eventID, TenantID: ev.TenantID, Address: ev.ToAddress, Verdict: verdict}
+		if err := p.qc.Emit(ctx, events.KindAddressRejected, rejection); err != nil {
+			l.Error("REJECTION_EMIT_FAILED", slog.Any("error", err))
+		}
+		return nil
 	}
-	l.Info("QUOTA_CHECKED")
+	l.Info("VALIDATION_PASSED", vattrs...)
+	rec.Step("validated", string(verdict.Status))
+	// From here on the recipient is the bare address, without any display
+	// name, so that it matches seeds and is usable as RCPT TO.
+	to := verdict.Address
+	rec.Recipient = to
 
-	fromAddr, err := p.emailResolver.Resolve(ctx, ev.TenantID)
+	recipientProvider := p.inboxes.Detect(ctx, to)
+	rec.Provider = recipientProvider
+	l = l.With(slog.String("recipient_provider", string(recipientProvider)))
+
+	today := p.tc.Today(ev.TenantID)
+	senders, err := p.emailResolver.Senders(ctx, ev.TenantID)
 	if err != nil {
 		l.Error("ADDRESS_RESOLVE_FAILED", slog.Any("error", err))
 		return err
 	}
+	candidates := senders
+	if ev.From != "" {
+		if !slices.ContainsFunc(senders, func(s string) bool { return strings.EqualFold(s, ev.From) }) {
+			l.Warn("SENDER_NOT_ALLOWED", slog.String("from", ev.From))
+			rec.Step("sender_not_allowed", ev.From)
+			p.saveHistory(ctx, l, rec)
+			return nil
+		}
+		candidates = []string{ev.From}
+	}
+	scope, r, err := p.pickSender(ctx, ev.TenantID, senders, candidates, recipientProvider, today)
+	if err != nil {
+		l.Error("QUOTA_CHECK_FAILED", slog.Any("error", err))
+		return err
+	}
+	if r <= 0 {
+		l.Warn("QUOTA_EXHAUSTED", slog.Int("senders", len(candidates)))
+		rec.Step("quota_exhausted", "")
+		p.saveHistory(ctx, l, rec)
+		return nil
+	}
+	fromAddr := scope.Sender
+	rec.Sender = fromAddr
+	l = l.With(slog.String("from", fromAddr))
+	l.Info("QUOTA_CHECKED", slog.Int("remaining", r))
+
 	prov, err := p.pf.Get(ev.TenantID)
 	if err != nil {
 		l.Error("PROVIDER_SELECT_FAILED", slog.Any("error", err))
 		return err
 	}
 
+	msg := &providers.Message{
+		From:       fromAddr,
+		To:         to,
+		ReplyTo:    ev.ReplyTo,
+		Subject:    ev.Subject,
+		Text:       ev.Body,
+		HTML:       ev.HTMLBody,
+		MessageID:  providers.MessageID(eventID, scope.Domain),
+		InReplyTo:  ev.InReplyTo,
+		References: ev.References,
+		Headers:    ev.Headers,
+	}
+	p.tags.Stamp(ev.TenantID, msg)
+	rec.MessageID = msg.MessageID
+
 	delivered := false
 	delay := p.rp.InitialDelay
 	for i := 0; i <= p.rp.MaxRetries; i++ {
 		l.Info("SEND_ATTEMPT", slog.Int("attempt", i+1))
-		if err := prov.Send(ctx, fromAddr, ev.ToAddress, ev.Subject, ev.Body); err == nil {
+		rec.Attempts = i + 1
+		err := prov.Send(ctx, msg)
+		if err == nil {
 			delivered = true
 			l.Info("SEND_SUCCESS", slog.Int("attempt", i+1))
+			rec.Step("sent", "")
 			break
-		} else {
-			fmt.Println("error while sending email: ", err)
 		}
+		rec.Step("send_failed", err.Error())
 		l.Warn("SEND_FAIL", slog.Int("attempt", i+1), slog.Any("error", err))
 		time.Sleep(delay)
 		delay *= 2
 	}
 
-	bounced, _ := prov.CheckBounce(ctx, ev.ToAddress, ev.Subject, ev.Body)
-	opened, _ := prov.CheckOpen(ctx, ev.ToAddress, ev.Subject, ev.Body)
-	spam, _ := prov.CheckSpam(ctx, ev.ToAddress, ev.Subject, ev.Body)
+	bounced, _ := prov.CheckBounce(ctx, msg)
+	opened, _ := prov.CheckOpen(ctx, msg)
+	spam, _ := prov.CheckSpam(ctx, msg)
 	l.Info("STATUS_RECONCILED",
 		slog.Bool("delivered", delivered),
 		slog.Bool("bounced", bounced),
@@ -95,30 +275,203 @@ func (p *Processor) handle(ctx context.Context, ev *queue.SendEmailEvent) error
 		slog.Bool("spam", spam),
 	)
 
-	score := calcScore(delivered, bounced, opened, spam)
-	_ = p.qs.SaveScore(ctx, ev.TenantID, now.Format("2006-01-02"), score)
-	l.Info("SCORE_SAVED", slog.Int("score", score))
+	if delivered {
+		if replied, err := p.replies.Plan(ctx, ev.TenantID, msg); err != nil {
+			l.Error("SEED_REPLY_PLAN_FAILED", slog.Any("error", err))
+		} else if replied {
+			l.Info("SEED_REPLY_PLANNED")
+			rec.Step("reply_planned", "")
+		}
+		if planned, err := p.rescuer.Plan(ctx, ev.TenantID, msg); err != nil {
+			l.Error("SEED_RESCUE_PLAN_FAILED", slog.Any("error", err))
+		} else if planned {
+			rec.Step("rescue_planned", "")
+		}
+	}
 
-	_, rem, _ := p.qs.DeductQuota(ctx, ev.TenantID, now.Format("2006-01-02"))
-	log.Printf("sent, rem %d", rem)
+	outs := outcomes(delivered, bounced, opened, spam)
+	score := p.scores.Score(outs)
+	rec.Step("reconciled", fmt.Sprint(outs))
+	rec.Outcomes, rec.Score = outs, score
+	_ = p.qs.SaveScore(ctx, scope, today, quota.ScoreRecord{
+		Provider: recipientProvider,
+		Outcomes: outs,
+		Score:    score,
+	})
+	l.Info("SCORE_SAVED", slog.Float64("score", score), slog.Any("outcomes", outs))
+
+	_, rem, _ := p.qs.DeductQuota(ctx, scope, today)
+	_ = p.qs.DeductProviderLimit(ctx, scope, recipientProvider, today)
 	l.Info("QUOTA_DEDUCTED", slog.Int("remaining", rem))
 
+	p.saveHistory(ctx, l, rec)
 	l.Info("DONE")
 	return nil
 }
 
-func calcScore(delivered, bounced, opened, spam bool) int {
-	score := 0
-	if delivered {
-		score += 2
-	} else if bounced {
-		score -= 1
+// sendSeedReply sends a seed's reply from the seed's own mailbox. Replies use
+// no quota. Once sent, a reply is scored for the sender it answers as a
+// followup with the replied outcome.
+func (p *Processor) sendSeedReply(ctx context.Context, l *slog.Logger, ev *queue.SendEmailEvent, rec history.Record) error {
+	rec.Sender, rec.Followup = ev.From, true
+	l = l.With(slog.String("from", ev.From), slog.String("in_reply_to", ev.InReplyTo))
+	msg, err := p.replies.Send(ctx, ev)
+	if err != nil {
+		l.Error("SEED_REPLY_FAILED", slog.Any("error", err))
+		return err
+	}
+	rec.MessageID, rec.Attempts = msg.MessageID, 1
+	rec.Step("seed_reply_sent", ev.InReplyTo)
+	l.Info("SEED_REPLY_SENT")
+
+	outs := []quota.Outcome{quota.Replied}
+	score := p.scores.Score(outs)
+	rec.Provider = p.inboxes.Detect(ctx, ev.From)
+	rec.Outcomes, rec.Score = outs, score
+	err = p.qs.SaveScore(ctx, quota.SenderScope(ev.TenantID, ev.ToAddress), p.tc.Today(ev.TenantID), quota.ScoreRecord{
+		Provider: rec.Provider,
+		Outcomes: outs,
+		Score:    score,
+		Followup: true,
+	})
+	if err != nil {
+		l.Error("SCORE_SAVE_FAILED", slog.Any("error", err))
+	}
+	p.saveHistory(ctx, l, rec)
+	return nil
+}
+
+// rescueSeed moves a warmup message out of a seed's spam folder and labels
+// it. A rescue is scored for the original sender as a followup with the spam
+// and rescued outcomes.
+func (p *Processor) rescueSeed(ctx context.Context, l *slog.Logger, ev *queue.SendEmailEvent, rec history.Record) error {
+	rec.Sender, rec.Recipient, rec.MessageID = ev.ToAddress, ev.From, ev.InReplyTo
+	rec.Followup = true
+	l = l.With(slog.String("seed", ev.From), slog.String("message_id", ev.InReplyTo))
+	rescued, err := p.rescuer.Rescue(ctx, ev)
+	if err != nil {
+		l.Error("SEED_RESCUE_FAILED", slog.Any("error", err))
+		return err
+	}
+	switch labelled, err := p.rescuer.Label(ctx, ev); {
+	case errors.Is(err, seeds.ErrCannotLabel):
+		l.Info("SEED_LABEL_UNSUPPORTED")
+	case err != nil:
+		l.Warn("SEED_LABEL_FAILED", slog.Any("error", err))
+	case labelled:
+		l.Info("SEED_LABELLED")
+		rec.Step("labelled", "")
+	}
+	if !rescued {
+		l.Info("SEED_NOT_IN_SPAM")
+		rec.Step("not_in_spam", "")
+		p.saveHistory(ctx, l, rec)
+		return nil
+	}
+
+	outs := []quota.Outcome{quota.Spam, quota.Rescued}
+	score := p.scores.Score(outs)
+	rec.Provider = p.inboxes.Detect(ctx, ev.From)
+	rec.Outcomes, rec.Score = outs, score
+	err = p.qs.SaveScore(ctx, quota.SenderScope(ev.TenantID, ev.ToAddress), p.tc.Today(ev.TenantID), quota.ScoreRecord{
+		Provider: rec.Provider,
+		Outcomes: outs,
+		Score:    score,
+		Followup: true,
+	})
+	if err != nil {
+		l.Error("SCORE_SAVE_FAILED", slog.Any("error", err))
+	}
+	l.Info("SEED_RESCUED")
+	rec.Step("rescued", "")
+	p.saveHistory(ctx, l, rec)
+	return nil
+}
+
+// leaseRecheck is how long an event claimed by another worker is parked
+// before its claim is looked at again.
+const leaseRecheck = time.Minute
+
+// failureRetry is how long an event whose processing failed is parked
+// before it is tried again.
+const failureRetry = time.Minute
+
+// markDone records the event as processed, so that a redelivery is dropped
+// even after its processing lease has expired.
+func (p *Processor) markDone(ctx context.Context, l *slog.Logger, ev *queue.SendEmailEvent) {
+	if ev.EventID == "" {
+		return
+	}
+	if err := p.dedup.Done(ctx, ev.TenantID, ev.EventID); err != nil {
+		l.Error("DEDUP_MARK_FAILED", slog.Any("error", err))
+	}
+}
+
+// saveHistory records a finished event. History is for analytics only, so a
+// failure is logged and does not fail the event.
+func (p *Processor) saveHistory(ctx context.Context, l *slog.Logger, rec history.Record) {
+	rec.ProcessedAt = time.Now()
+	if err := p.history.Save(ctx, rec); err != nil {
+		l.Error("HISTORY_SAVE_FAILED", slog.Any("error", err))
+	}
+}
+
+// pickSender rotates through the candidate senders and returns the first one
+// with quota, and any limit towards the recipient's provider, left on its own
+// scope and on every rollup. The remaining quota is the smallest of those;
+// zero means every candidate is exhausted. Day-start quotas are derived from
+// all of the tenant's senders.
+func (p *Processor) pickSender(ctx context.Context, tenantID string, senders, candidates []string, prov inbox.Provider, date string) (quota.Scope, int, error) {
+	initial := quota.InitialQuota(senders)
+
+	start := int(p.next.Add(1))
+	for i := range candidates {
+		scope := quota.SenderScope(tenantID, candidates[(start+i)%len(candidates)])
+		left := -1
+		for _, s := range scope.Rollups() {
+			if _, err := p.qs.InitQuota(ctx, s, date, initial(s)); err != nil {
+				return quota.Scope{}, 0, err
+			}
+			r, err := p.qs.GetRemainingQuota(ctx, s, date)
+			if err != nil {
+				return quota.Scope{}, 0, err
+			}
+			if left < 0 || r < left {
+				left = r
+			}
+			limit, limited, err := p.qs.ProviderLimit(ctx, s, prov, date)
+			if err != nil {
+				return quota.Scope{}, 0, err
+			}
+			if limited && limit < left {
+				left = limit
+			}
+		}
+		if left > 0 {
+			return scope, left, nil
+		}
+	}
+	return quota.Scope{}, 0, nil
+}
+
+// outcomes maps reconciled status to outcome dimensions. A send that never
+// went through counts as a hard bounce when the provider reported a bounce and
+// as a soft bounce otherwise. Replies are scored as followups once sent.
+func outcomes(delivered, bounced, opened, spam bool) []quota.Outcome {
+	var out []quota.Outcome
+	switch {
+	case delivered:
+		out = append(out, quota.Delivered)
+	case bounced:
+		out = append(out, quota.HardBounce)
+	default:
+		out = append(out, quota.SoftBounce)
 	}
 	if opened {
-		score += 1
+		out = append(out, quota.Opened)
 	}
 	if spam {
-		score -= 2
+		out = append(out, quota.Spam)
 	}
-	return score
+	return out
 }
@@ -8,11 +8,11 @@ import (
 )
 
 type Provider interface {
-	Send(ctx context.Context, from, to, subj, body string) error
-	CheckDelivery(ctx context.Context, to, subj, body string) (bool, error)
-	CheckBounce(ctx context.Context, to, subj, body string) (bool, error)
-	CheckOpen(ctx context.Context, to, subj, body string) (bool, error)
-	CheckSpam(ctx context.Context, to, subj, body string) (bool, error)
+	Send(ctx context.Context, msg *Message) error
+	CheckDelivery(ctx context.Context, msg *Message) (bool, error)
+	CheckBounce(ctx context.Context, msg *Message) (bool, error)
+	CheckOpen(ctx context.Context, msg *Message) (bool, error)
+	CheckSpam(ctx context.Context, msg *Message) (bool, error)
 }
 
 type Factory struct {
@@ -30,15 +30,19 @@ func (f *Factory) Get(tenantID string) (Provider, error) {
 	if !ok {
 		return nil, errors.New("no provider for tenant: " + tenantID)
 	}
-	switch t {
+	return New(t, f.smtpConfig, f.googleOAuthConfig)
+}
+
+// New builds the provider named by key from the given credentials, e.g. for
+// a mailbox that is not a tenant's.
+func New(key string, smtpCfg config.SMTPConfig, googleOAuthCfg config.GoogleOAuthConfig) (Provider, error) {
+	switch key {
 	case "smtp":
-		return NewSMTPProvider(f.smtpConfig), nil
+		return NewSMTPProvider(smtpCfg), nil
 	case "google":
-		return NewGoogleProvider(f.googleOAuthConfig), nil
-
+		return NewGoogleProvider(googleOAuthCfg), nil
 	case "outlook":
-		// TODO
-		return nil, nil
+		return nil, errors.New("outlook provider is not implemented")
 	}
-	return nil, errors.New("unknown provider: " + t)
+	return nil, errors.New("unknown provider: " + key)
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/ilivestrong/email_warmup_service/internal/config"
@@ -45,15 +46,14 @@ func NewGoogleProvider(cfg config.GoogleOAuthConfig) Provider {
 	}
 }
 
-func (g *GoogleProvider) Send(ctx context.Context, from, to, subject, body string) error {
-	msg := []byte(fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s", from, to, subject, body))
-	raw := base64.URLEncoding.EncodeToString(msg)
-	_, err := g.service.Users.Messages.Send(from, &gmail.Message{Raw: raw}).Do()
+func (g *GoogleProvider) Send(ctx context.Context, msg *Message) error {
+	raw := base64.URLEncoding.EncodeToString(msg.Bytes())
+	_, err := g.service.Users.Messages.Send(msg.From, &gmail.Message{Raw: raw}).Do()
 	return err
 }
 
-func (g *GoogleProvider) CheckDelivery(ctx context.Context, to, subj, body string) (bool, error) {
-	query := fmt.Sprintf("to:%s subject:%q", to, subj)
+func (g *GoogleProvider) CheckDelivery(ctx context.Context, msg *Message) (bool, error) {
+	query := fmt.Sprintf("to:%s %s", msg.To, match(msg))
 	resp, err := g.service.Users.Messages.List("me").Q(query).Do()
 	if err != nil {
 		return false, err
@@ -61,8 +61,12 @@ func (g *GoogleProvider) CheckDelivery(ctx context.Context, to, subj, body strin
 	return len(resp.Messages) > 0, nil
 }
 
-func (g *GoogleProvider) CheckBounce(ctx context.Context, to, subj, body string) (bool, error) {
-	query := fmt.Sprintf("from:mailer-daemon@googlemail.com to:%s subject:bounced", to)
+func (g *GoogleProvider) CheckBounce(ctx context.Context, msg *Message) (bool, error) {
+	query := fmt.Sprintf("from:mailer-daemon@googlemail.com to:%s subject:bounced", msg.To)
+	if bodyTagged(msg) {
+		// Bounce notices quote the original message, code included.
+		query = fmt.Sprintf("from:mailer-daemon@googlemail.com %q", msg.Tag)
+	}
 	resp, err := g.service.Users.Messages.List("me").Q(query).Do()
 	if err != nil {
 		return false, err
@@ -70,8 +74,8 @@ func (g *GoogleProvider) CheckBounce(ctx context.Context, to, subj, body string)
 	return len(resp.Messages) > 0, nil
 }
 
-func (g *GoogleProvider) CheckOpen(ctx context.Context, to, subj, body string) (bool, error) {
-	query := fmt.Sprintf("to:%s subject:%q label:UNREAD", to, subj)
+func (g *GoogleProvider) CheckOpen(ctx context.Context, msg *Message) (bool, error) {
+	query := fmt.Sprintf("to:%s %s label:UNREAD", msg.To, match(msg))
 	resp, err := g.service.Users.Messages.List("me").Q(query).Do()
 	if err != nil {
 		return false, err
@@ -80,11 +84,24 @@ func (g *GoogleProvider) CheckOpen(ctx context.Context, to, subj, body string) (
 	return len(resp.Messages) == 0, nil
 }
 
-func (g *GoogleProvider) CheckSpam(ctx context.Context, to, subj, body string) (bool, error) {
-	query := fmt.Sprintf("to:%s subject:%q in:spam", to, subj)
+func (g *GoogleProvider) CheckSpam(ctx context.Context, msg *Message) (bool, error) {
+	query := fmt.Sprintf("to:%s %s in:spam", msg.To, match(msg))
 	resp, err := g.service.Users.Messages.List("me").Q(query).Do()
 	if err != nil {
 		return false, err
 	}
 	return len(resp.Messages) > 0, nil
 }
+
+// match narrows a Gmail search to msg: by its tracking code when the code is
+// in the body, where Gmail can search it, and by its Message-ID otherwise.
+func match(msg *Message) string {
+	if bodyTagged(msg) {
+		return fmt.Sprintf("%q", msg.Tag)
+	}
+	return "rfc822msgid:" + msg.MessageID
+}
+
+func bodyTagged(msg *Message) bool {
+	return msg.Tag != "" && strings.Contains(msg.Text+msg.HTML, msg.Tag)
+}
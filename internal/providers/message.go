@@ -0,0 +1,112 @@
+package providers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Message is one email to send. Text, HTML or both may be set.
+type Message struct {
+	From, To  string
+	ReplyTo   string
+	Subject   string
+	Text      string
+	HTML      string
+	MessageID string // e.g. <id@host>
+	InReplyTo string
+	// References lists the earlier messages of the thread, oldest first.
+	// InReplyTo is added to it when sent.
+	References []string
+	Headers    map[string]string
+	Tag        string // warmup tracking code, sent in TagHeader
+}
+
+// TagHeader carries the tracking code of warmup mail.
+const TagHeader = "X-Warmup-Tag"
+
+// Bytes renders the message as RFC 5322 text. A message with both bodies is
+// sent as multipart/alternative.
+func (m *Message) Bytes() []byte {
+	var buf bytes.Buffer
+	header := func(k, v string) {
+		if v != "" {
+			fmt.Fprintf(&buf, "%s: %s\r\n", k, v)
+		}
+	}
+	header("From", m.From)
+	header("To", m.To)
+	header("Reply-To", m.ReplyTo)
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", m.MessageID)
+	header("In-Reply-To", m.InReplyTo)
+	header("References", strings.Join(m.references(), " "))
+	header(TagHeader, m.Tag)
+	keys := make([]string, 0, len(m.Headers))
+	for k := range m.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		header(textproto.CanonicalMIMEHeaderKey(k), m.Headers[k])
+	}
+	header("MIME-Version", "1.0")
+
+	switch {
+	case m.HTML == "":
+		writePart(&buf, "text/plain", m.Text)
+	case m.Text == "":
+		writePart(&buf, "text/html", m.HTML)
+	default:
+		w := multipart.NewWriter(&buf)
+		header("Content-Type", "multipart/alternative; boundary="+w.Boundary())
+		buf.WriteString("\r\n")
+		for _, p := range []struct{ typ, body string }{{"text/plain", m.Text}, {"text/html", m.HTML}} {
+			pw, _ := w.CreatePart(textproto.MIMEHeader{
+				"Content-Type":              {p.typ + "; charset=utf-8"},
+				"Content-Transfer-Encoding": {"quoted-printable"},
+			})
+			qp := quotedprintable.NewWriter(pw)
+			qp.Write([]byte(p.body))
+			qp.Close()
+		}
+		w.Close()
+	}
+	return buf.Bytes()
+}
+
+// references returns the thread for the References header: References
+// followed by InReplyTo unless it is already listed.
+func (m *Message) references() []string {
+	if m.InReplyTo == "" || slices.Contains(m.References, m.InReplyTo) {
+		return m.References
+	}
+	return append(slices.Clip(m.References), m.InReplyTo)
+}
+
+func writePart(buf *bytes.Buffer, typ, body string) {
+	fmt.Fprintf(buf, "Content-Type: %s; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n", typ)
+	qp := quotedprintable.NewWriter(buf)
+	qp.Write([]byte(body))
+	qp.Close()
+}
+
+// MessageID builds a Message-ID header value from an ID unique to the message
+// and the sending domain. IDs that are not safe in the header are hashed.
+func MessageID(id, domain string) string {
+	if id == "" || strings.Trim(id, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789.-_") != "" {
+		sum := sha256.Sum256([]byte(id))
+		id = hex.EncodeToString(sum[:16])
+	}
+	return "<" + id + "@" + domain + ">"
+}
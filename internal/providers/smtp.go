@@ -22,22 +22,21 @@ func NewSMTPProvider(cfg config.SMTPConfig) *SMTPProvider {
 	}
 }
 
-func (s *SMTPProvider) Send(ctx context.Context, from, to, subj, body string) error {
+func (s *SMTPProvider) Send(ctx context.Context, msg *Message) error {
 	auth := smtp.PlainAuth("", s.username, s.password, s.host)
 	addr := fmt.Sprintf("%s:%s", s.host, s.port)
-	msg := []byte(fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s", from, to, subj, body))
-	return smtp.SendMail(addr, auth, from, []string{to}, msg)
+	return smtp.SendMail(addr, auth, msg.From, []string{msg.To}, msg.Bytes())
 }
 
-func (s *SMTPProvider) CheckDelivery(ctx context.Context, to, subj, body string) (bool, error) {
+func (s *SMTPProvider) CheckDelivery(ctx context.Context, msg *Message) (bool, error) {
 	return true, nil
 }
-func (s *SMTPProvider) CheckBounce(ctx context.Context, to, subj, body string) (bool, error) {
+func (s *SMTPProvider) CheckBounce(ctx context.Context, msg *Message) (bool, error) {
 	return false, nil
 }
-func (s *SMTPProvider) CheckOpen(ctx context.Context, to, subj, body string) (bool, error) {
+func (s *SMTPProvider) CheckOpen(ctx context.Context, msg *Message) (bool, error) {
 	return true, nil
 }
-func (s *SMTPProvider) CheckSpam(ctx context.Context, to, subj, body string) (bool, error) {
+func (s *SMTPProvider) CheckSpam(ctx context.Context, msg *Message) (bool, error) {
 	return false, nil
 }
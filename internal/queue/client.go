@@ -16,13 +16,19 @@ type (
 	Client interface {
 		Publish(ctx context.Context, event *SendEmailEvent) error
 		Consume(ctx context.Context, handler SendEmailEventHandler) error
+		// Emit publishes a JSON notification of the given kind for other
+		// services to consume, e.g. events.KindDailyReport.
+		Emit(ctx context.Context, kind string, payload any) error
 	}
 )
 
-func NewClient(url string) (Client, error) {
+// NewClient connects to the queue at url. Events are queued per tenant and
+// workers serve tenants in proportion to weight; tenants are the ones known
+// up front.
+func NewClient(url string, tenants []string, weight func(tenantID string) float64) (Client, error) {
 	if strings.HasPrefix(url, "amqp://") ||
 		strings.HasPrefix(url, "amqps://") {
-		return rmq.New(url)
+		return rmq.New(url, tenants, weight)
 	}
 	return nil, errors.New("unsupported queue URL scheme")
 }
@@ -1,12 +1,234 @@
 package events
 
-import "context"
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Schema versions of SendEmailEvent. Version 1 messages predate the version
+// field and carry only the recipient, tenant, subject and body.
+const (
+	V1             = 1
+	V2             = 2
+	CurrentVersion = V2
+)
+
+// Event priorities. Higher ones are processed first. Warmup sends use the
+// default; replies and rescue actions use PriorityEngagement so they happen
+// promptly.
+const (
+	PriorityWarmup     = 0
+	PriorityEngagement = 5
+	MaxPriority        = 9
+)
+
+// Kinds of SendEmailEvent. Warmup sends go out from a tenant's sender; seed
+// replies answer one from the seed mailbox it was sent to. A seed rescue
+// sends nothing: it moves the message named by InReplyTo out of the spam
+// folder of the seed in From. Seed replies and rescues are internal: only
+// the service itself may queue them.
+const (
+	KindWarmup     = ""
+	KindSeedReply  = "seed_reply"
+	KindSeedRescue = "seed_rescue"
+)
+
+// Internal reports whether the event is of a kind only the service queues.
+func (e *SendEmailEvent) Internal() bool {
+	return e.Kind == KindSeedReply || e.Kind == KindSeedRescue
+}
 
 type SendEmailEvent struct {
-	ToAddress string `json:"toAddress"`
+	Version int    `json:"version"`
+	Kind    string `json:"kind,omitempty"`
+	// EventID is the producer's idempotency key. Deliveries sharing an ID
+	// are processed once.
+	EventID   string `json:"eventId,omitempty"`
 	TenantID  string `json:"tenantId"`
-	Subject   string `json:"subject"`
-	Body      string `json:"body"`
+	ToAddress string `json:"toAddress"`
+	// From picks one of the tenant's sender mailboxes instead of letting the
+	// processor rotate through them.
+	From      string            `json:"from,omitempty"`
+	ReplyTo   string            `json:"replyTo,omitempty"`
+	Subject   string            `json:"subject"`
+	Body      string            `json:"body"`
+	HTMLBody  string            `json:"htmlBody,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	InReplyTo string            `json:"inReplyTo,omitempty"`
+	// References lists the earlier message IDs of the thread, oldest first,
+	// so that a reply keeps the whole chain.
+	References []string `json:"references,omitempty"`
+	// SendAt delays the send until the given time.
+	SendAt        *time.Time `json:"sendAt,omitempty"`
+	Priority      int        `json:"priority,omitempty"`
+	CorrelationID string     `json:"correlationId,omitempty"`
 }
 
 type SendEmailEventHandler func(ctx context.Context, event *SendEmailEvent) error
+
+// Kinds of the events emitted through Client.Emit, used as routing keys.
+const (
+	KindDailyReport     = "quota.daily_report"
+	KindAddressRejected = "validator.address_rejected"
+	KindCreditsLow      = "validator.credits_low"
+)
+
+var ErrUnsupportedVersion = errors.New("unsupported event version")
+
+// ValidationError reports an invalid field of a SendEmailEvent.
+type ValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+}
+
+// reservedHeaders are set from the event's own fields and may not be
+// overridden through Headers.
+var reservedHeaders = map[string]bool{
+	"From": true, "To": true, "Cc": true, "Bcc": true, "Reply-To": true,
+	"Subject": true, "Message-Id": true, "In-Reply-To": true, "References": true,
+	"Date": true, "Mime-Version": true, "Content-Type": true, "Content-Transfer-Encoding": true,
+	"X-Warmup-Tag": true,
+}
+
+// Decode parses a queued event. Messages without a version are read as
+// version 1 and upgraded; version 2 messages are decoded strictly. The
+// result is validated, and every problem found is returned as a
+// *ValidationError joined into one error. Internal kinds are only accepted
+// from a trusted publisher, i.e. the service itself.
+func Decode(b []byte, trusted bool) (*SendEmailEvent, error) {
+	var head struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(b, &head); err != nil {
+		return nil, err
+	}
+
+	e := new(SendEmailEvent)
+	switch head.Version {
+	case 0, V1:
+		var v1 struct {
+			EventID   string `json:"eventId"`
+			ToAddress string `json:"toAddress"`
+			TenantID  string `json:"tenantId"`
+			Subject   string `json:"subject"`
+			Body      string `json:"body"`
+		}
+		if err := json.Unmarshal(b, &v1); err != nil {
+			return nil, err
+		}
+		e.EventID, e.ToAddress, e.TenantID, e.Subject, e.Body = v1.EventID, v1.ToAddress, v1.TenantID, v1.Subject, v1.Body
+	case V2:
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(e); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, head.Version)
+	}
+	e.Version = CurrentVersion
+
+	err := e.Validate()
+	if !trusted && e.Internal() {
+		err = errors.Join(err, &ValidationError{Field: "kind", Reason: fmt.Sprintf("%s events are internal to the service", e.Kind)})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Validate checks the event against the current schema.
+func (e *SendEmailEvent) Validate() error {
+	var errs []error
+	invalid := func(field, reason string) {
+		errs = append(errs, &ValidationError{Field: field, Reason: reason})
+	}
+	address := func(field, v string, required bool) {
+		if v == "" {
+			if required {
+				invalid(field, "required")
+			}
+			return
+		}
+		if _, err := mail.ParseAddress(v); err != nil {
+			invalid(field, err.Error())
+		}
+	}
+
+	if e.Version != CurrentVersion {
+		invalid("version", fmt.Sprintf("must be %d", CurrentVersion))
+	}
+	switch e.Kind {
+	case KindWarmup, KindSeedReply:
+		if e.Body == "" && e.HTMLBody == "" {
+			invalid("body", "body or htmlBody is required")
+		}
+	case KindSeedRescue:
+		if e.From == "" || e.InReplyTo == "" {
+			invalid("inReplyTo", "a rescue needs the seed in from and the message in inReplyTo")
+		}
+	default:
+		invalid("kind", fmt.Sprintf("unknown kind %q", e.Kind))
+	}
+	if e.TenantID == "" {
+		invalid("tenantId", "required")
+	}
+	address("toAddress", e.ToAddress, true)
+	address("from", e.From, false)
+	address("replyTo", e.ReplyTo, false)
+	if strings.ContainsAny(e.Subject, "\r\n") {
+		invalid("subject", "contains a line break")
+	}
+	for name, v := range e.Headers {
+		canon := textproto.CanonicalMIMEHeaderKey(name)
+		switch {
+		case !validHeaderName(name):
+			invalid("headers", fmt.Sprintf("%q is not a valid header name", name))
+		case reservedHeaders[canon]:
+			invalid("headers", fmt.Sprintf("%s is set by the service", canon))
+		case strings.ContainsAny(v, "\r\n"):
+			invalid("headers", fmt.Sprintf("%s contains a line break", canon))
+		}
+	}
+	if e.InReplyTo != "" && !validMessageID(e.InReplyTo) {
+		invalid("inReplyTo", "must be a message ID like <id@host>")
+	}
+	for _, id := range e.References {
+		if !validMessageID(id) {
+			invalid("references", fmt.Sprintf("%q is not a message ID like <id@host>", id))
+		}
+	}
+	if e.Priority < 0 || e.Priority > MaxPriority {
+		invalid("priority", fmt.Sprintf("must be between 0 and %d", MaxPriority))
+	}
+	return errors.Join(errs...)
+}
+
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c <= ' ' || c > '~' || c == ':' {
+			return false
+		}
+	}
+	return true
+}
+
+func validMessageID(id string) bool {
+	return len(id) > 2 && id[0] == '<' && id[len(id)-1] == '>' &&
+		strings.Contains(id, "@") && !strings.ContainsAny(id, " \r\n")
+}
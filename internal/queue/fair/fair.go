@@ -0,0 +1,112 @@
+package fair
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// Scheduler hands out items queued per tenant so that, while several tenants
+// have work waiting, each gets a share of Next calls proportional to its
+// weight (stride scheduling). A tenant that was idle rejoins at the current
+// virtual time instead of cashing in the turns it skipped.
+//
+// Priority only orders a tenant's own items: its highest-priority item is
+// handed out on its turn. Turns are shared by weight alone, so a tenant
+// cannot take others' turns by raising the priority of its events.
+type Scheduler[T any] struct {
+	weight func(tenant string) float64
+
+	mu     sync.Mutex
+	lanes  map[string]*lane[T]
+	vtime  float64
+	notify chan struct{} // closed and replaced whenever an item is pushed
+}
+
+type lane[T any] struct {
+	items []entry[T] // highest priority first, FIFO within a priority
+	pass  float64
+}
+
+type entry[T any] struct {
+	priority int
+	item     T
+}
+
+// New returns a scheduler that looks up each tenant's weight with weight.
+// Weights that are not positive count as 1.
+func New[T any](weight func(tenant string) float64) *Scheduler[T] {
+	return &Scheduler[T]{weight: weight, lanes: map[string]*lane[T]{}, notify: make(chan struct{})}
+}
+
+func (s *Scheduler[T]) Push(tenant string, priority int, item T) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.lanes[tenant]
+	if !ok {
+		l = &lane[T]{}
+		s.lanes[tenant] = l
+	}
+	if len(l.items) == 0 && l.pass < s.vtime {
+		l.pass = s.vtime
+	}
+	i := len(l.items)
+	for i > 0 && l.items[i-1].priority < priority {
+		i--
+	}
+	l.items = slices.Insert(l.items, i, entry[T]{priority, item})
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+// Next blocks until an item is available or ctx is done and returns it with
+// its tenant.
+func (s *Scheduler[T]) Next(ctx context.Context) (string, T, error) {
+	for {
+		s.mu.Lock()
+		tenant, item, ok := s.pop()
+		wait := s.notify
+		s.mu.Unlock()
+		if ok {
+			return tenant, item, nil
+		}
+		select {
+		case <-ctx.Done():
+			var zero T
+			return "", zero, ctx.Err()
+		case <-wait:
+		}
+	}
+}
+
+// pop takes the head of the waiting lane that is furthest behind.
+func (s *Scheduler[T]) pop() (string, T, bool) {
+	var (
+		tenant string
+		next   *lane[T]
+	)
+	for t, l := range s.lanes {
+		if len(l.items) == 0 {
+			continue
+		}
+		if next == nil || l.pass < next.pass || (l.pass == next.pass && t < tenant) {
+			tenant, next = t, l
+		}
+	}
+	if next == nil {
+		var zero T
+		return "", zero, false
+	}
+	item := next.items[0].item
+	next.items = next.items[1:]
+	if len(next.items) == 0 {
+		next.items = nil
+	}
+	s.vtime = next.pass
+	w := s.weight(tenant)
+	if w <= 0 {
+		w = 1
+	}
+	next.pass += 1 / w
+	return tenant, item, true
+}
@@ -3,15 +3,36 @@ package rmq
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"log"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/ilivestrong/email_warmup_service/internal/queue/events"
+	"github.com/ilivestrong/email_warmup_service/internal/queue/fair"
 	"github.com/streadway/amqp"
 )
 
-const defaultQueue = "send_email"
+const (
+	// defaultQueue takes events from producers. They are routed from there
+	// to per-tenant priority queues (x-max-priority) named
+	// tenantQueuePrefix + tenant ID, so RabbitMQ hands out a tenant's urgent
+	// events ahead of its backlog.
+	defaultQueue      = "send_email"
+	tenantQueuePrefix = "send_email."
+	// tenantPrefetch bounds how many unacknowledged events of one tenant a
+	// replica holds; the rest wait in RabbitMQ.
+	tenantPrefetch = 10
+	// eventsExchange is a topic exchange carrying Emit notifications keyed by
+	// their kind.
+	eventsExchange = "warmup_events"
+	// trustedHeader marks events published by the service itself, which
+	// alone may carry internal kinds. Routing from defaultQueue drops it.
+	trustedHeader = "x-warmup-trusted"
+	// requeueDelay is how long a worker waits before it hands an event it
+	// failed to process back to RabbitMQ.
+	requeueDelay = 5 * time.Second
+)
 
 type (
 	SendEmailEvent        = events.SendEmailEvent
@@ -20,10 +41,24 @@ type (
 	Client struct {
 		conn    *amqp.Connection
 		channel *amqp.Channel
+		tenants []string
+		fair    *fair.Scheduler[amqp.Delivery]
+
+		mu        sync.Mutex
+		declared  map[string]bool // tenant queues declared
+		consuming map[string]bool // tenant queues consumed
+		started   bool
+
+		startOnce sync.Once
+		startErr  error
+		closeOnce sync.Once
 	}
 )
 
-func New(url string) (*Client, error) {
+// New connects to RabbitMQ. The queues of the given tenants are consumed from
+// the start; other tenants' queues once their first event is seen. Workers
+// are shared between tenants in proportion to weight.
+func New(url string, tenants []string, weight func(tenantID string) float64) (*Client, error) {
 	conn, err := amqp.Dial(url)
 	if err != nil {
 		return nil, err
@@ -34,52 +69,220 @@ func New(url string) (*Client, error) {
 		return nil, err
 	}
 	_, err = ch.QueueDeclare(defaultQueue, true, false, false, false, nil)
+	if err == nil {
+		err = ch.ExchangeDeclare(eventsExchange, amqp.ExchangeTopic, true, false, false, false, nil)
+	}
 	if err != nil {
 		ch.Close()
 		conn.Close()
 		return nil, err
 	}
-	return &Client{conn: conn, channel: ch}, nil
+	return &Client{
+		conn:      conn,
+		channel:   ch,
+		tenants:   tenants,
+		fair:      fair.New[amqp.Delivery](weight),
+		declared:  map[string]bool{},
+		consuming: map[string]bool{},
+	}, nil
 }
 
+// Publish validates and sends the event at the current schema version,
+// assigning it an EventID first if it has none. The ID is also set as the
+// AMQP message ID. Events published here are trusted, so they may be of an
+// internal kind.
 func (c *Client) Publish(ctx context.Context, event *SendEmailEvent) error {
+	if event.Version == 0 {
+		event.Version = events.CurrentVersion
+	}
+	if event.EventID == "" {
+		event.EventID = uuid.New().String()
+	}
+	if err := event.Validate(); err != nil {
+		return err
+	}
 	b, err := json.Marshal(event)
 	if err != nil {
 		return err
 	}
-	return c.channel.Publish("", defaultQueue, false, false, amqp.Publishing{ContentType: "application/json", Body: b})
+	if err := c.declareTenant(event.TenantID); err != nil {
+		return err
+	}
+	return c.channel.Publish("", tenantQueuePrefix+event.TenantID, false, false, amqp.Publishing{
+		ContentType:   "application/json",
+		MessageId:     event.EventID,
+		CorrelationId: event.CorrelationID,
+		Priority:      uint8(event.Priority),
+		Headers:       amqp.Table{trustedHeader: true},
+		Body:          b,
+	})
+}
+
+func (c *Client) Emit(ctx context.Context, kind string, payload any) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return c.channel.Publish(eventsExchange, kind, false, false, amqp.Publishing{ContentType: "application/json", Type: kind, Body: b})
 }
 
+// Consume runs one worker. Every worker of a Client draws from the same
+// weighted-fair scheduler over the tenant queues.
 func (c *Client) Consume(ctx context.Context, handler SendEmailEventHandler) error {
-	msgs, err := c.channel.Consume(defaultQueue, "", false, false, false, false, nil)
+	c.startOnce.Do(func() { c.startErr = c.start(ctx) })
+	if c.startErr != nil {
+		return c.startErr
+	}
+	for {
+		tenantID, msg, err := c.fair.Next(ctx)
+		if err != nil {
+			return c.Close()
+		}
+		trusted, _ := msg.Headers[trustedHeader].(bool)
+		e, err := events.Decode(msg.Body, trusted)
+		if err != nil {
+			log.Printf("invalid email event for tenant %s: %v", tenantID, err)
+			msg.Ack(false)
+			continue
+		}
+		if e.EventID == "" {
+			e.EventID = msg.MessageId
+		}
+		if e.Priority == 0 {
+			e.Priority = min(int(msg.Priority), events.MaxPriority)
+		}
+		if err := handler(ctx, e); err != nil {
+			// Requeue rather than drop, after a pause so that a failing
+			// dependency is not hammered with redeliveries.
+			log.Printf("failed to process event %s for tenant %s, requeueing: %v", e.EventID, tenantID, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(requeueDelay):
+			}
+			msg.Nack(false, true)
+		} else {
+			msg.Ack(false)
+		}
+	}
+}
+
+// start routes the shared queue into tenant queues and consumes the queues
+// of the configured tenants.
+func (c *Client) start(ctx context.Context) error {
+	c.mu.Lock()
+	c.started = true
+	c.mu.Unlock()
+
+	ch, err := c.conn.Channel()
+	if err != nil {
+		return err
+	}
+	msgs, err := ch.Consume(defaultQueue, "", false, false, false, false, nil)
 	if err != nil {
 		return err
 	}
+	for _, tenantID := range c.tenants {
+		if err := c.consumeTenant(tenantID); err != nil {
+			return err
+		}
+	}
+	go c.route(ctx, msgs)
+	return nil
+}
+
+// route moves each event from the shared queue to its tenant's queue. Only
+// the listed properties are copied, so producers cannot pass trustedHeader
+// through.
+func (c *Client) route(ctx context.Context, msgs <-chan amqp.Delivery) {
 	for {
 		select {
 		case <-ctx.Done():
-			return c.Close()
-		case msg := <-msgs:
-			e := new(SendEmailEvent)
-			if err := json.Unmarshal(msg.Body, e); err != nil {
-				log.Printf("invalid email event: %v", err)
+			return
+		case msg, ok := <-msgs:
+			if !ok {
+				return
+			}
+			var head struct {
+				TenantID string `json:"tenantId"`
+			}
+			if err := json.Unmarshal(msg.Body, &head); err != nil || head.TenantID == "" {
+				log.Printf("invalid email event: no tenant (%v)", err)
 				msg.Ack(false)
 				continue
 			}
-			fmt.Printf("\n[NEW EVENT]: email: %s\n", e.ToAddress)
-			err := handler(ctx, e)
+			err := c.consumeTenant(head.TenantID)
+			if err == nil {
+				err = c.channel.Publish("", tenantQueuePrefix+head.TenantID, false, false, amqp.Publishing{
+					ContentType:   msg.ContentType,
+					MessageId:     msg.MessageId,
+					CorrelationId: msg.CorrelationId,
+					Priority:      msg.Priority,
+					Body:          msg.Body,
+				})
+			}
 			if err != nil {
-				fmt.Println("failed to process event")
-				msg.Nack(false, false)
-			} else {
-				msg.Ack(false)
+				log.Printf("failed to route event to tenant %s: %v", head.TenantID, err)
+				msg.Nack(false, true)
+				continue
 			}
+			msg.Ack(false)
 		}
 	}
 }
 
+func (c *Client) declareTenant(tenantID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.declared[tenantID] {
+		return nil
+	}
+	args := amqp.Table{"x-max-priority": int32(events.MaxPriority)}
+	if _, err := c.channel.QueueDeclare(tenantQueuePrefix+tenantID, true, false, false, false, args); err != nil {
+		return err
+	}
+	c.declared[tenantID] = true
+	return nil
+}
+
+// consumeTenant declares the tenant's queue and, once workers are running,
+// feeds it into the fair scheduler on a channel of its own.
+func (c *Client) consumeTenant(tenantID string) error {
+	if err := c.declareTenant(tenantID); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.started || c.consuming[tenantID] {
+		return nil
+	}
+	ch, err := c.conn.Channel()
+	if err != nil {
+		return err
+	}
+	if err := ch.Qos(tenantPrefetch, 0, false); err != nil {
+		ch.Close()
+		return err
+	}
+	msgs, err := ch.Consume(tenantQueuePrefix+tenantID, "", false, false, false, false, nil)
+	if err != nil {
+		ch.Close()
+		return err
+	}
+	c.consuming[tenantID] = true
+	go func() {
+		for msg := range msgs {
+			c.fair.Push(tenantID, int(msg.Priority), msg)
+		}
+	}()
+	return nil
+}
+
 func (c *Client) Close() error {
-	time.Sleep(100 * time.Millisecond)
-	c.channel.Close()
-	return c.conn.Close()
+	var err error
+	c.closeOnce.Do(func() {
+		time.Sleep(100 * time.Millisecond)
+		c.channel.Close()
+		err = c.conn.Close()
+	})
+	return err
 }
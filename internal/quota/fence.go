@@ -0,0 +1,25 @@
+package quota
+
+import "errors"
+
+// ErrFenced is returned by a fenced write from outside any term, or from a
+// term older than one that has already written under the same name.
+var ErrFenced = errors.New("write fenced off by a newer leadership term")
+
+// Fence guards writes that only the current leader may make. The store keeps
+// the highest term that has written under each name, in the same transaction
+// as the write, and refuses writes from older terms. So a leader that was
+// paused past its lease cannot overwrite its successor's work. The zero
+// Fence guards nothing.
+type Fence struct {
+	Name string
+	Term int64
+}
+
+func fenceKey(f Fence) string { return "fence:" + f.Name }
+
+// admits reports whether f may write when seen is the highest term that has
+// written under its name.
+func (f Fence) admits(seen int64) bool {
+	return f.Name == "" || (f.Term > 0 && f.Term >= seen)
+}
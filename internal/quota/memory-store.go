@@ -0,0 +1,250 @@
+package quota
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ilivestrong/email_warmup_service/internal/inbox"
+)
+
+// memoryStore keeps everything in process memory. Quotas and provider limits
+// expire like their Redis keys do; nothing survives a restart.
+type memoryStore struct {
+	mu         sync.Mutex
+	now        func() time.Time
+	counters   map[string]counter
+	scores     map[string][]ScoreRecord
+	reputation map[string][]Reputation
+	tenants    map[string]struct{}
+	reports    map[string]DailyReport
+	lastRuns   map[string]time.Time
+}
+
+type counter struct {
+	val     int
+	expires time.Time // zero means no expiry
+}
+
+func NewMemoryStore() Store { return NewMemoryStoreClock(time.Now) }
+
+// NewMemoryStoreClock is NewMemoryStore with quotas and provider limits
+// expiring according to now instead of the wall clock.
+func NewMemoryStoreClock(now func() time.Time) Store {
+	return &memoryStore{
+		now:        now,
+		counters:   map[string]counter{},
+		scores:     map[string][]ScoreRecord{},
+		reputation: map[string][]Reputation{},
+		tenants:    map[string]struct{}{},
+		reports:    map[string]DailyReport{},
+		lastRuns:   map[string]time.Time{},
+	}
+}
+
+// get must be called with mu held.
+func (m *memoryStore) get(key string) (counter, bool) {
+	c, ok := m.counters[key]
+	if ok && !c.expires.IsZero() && !m.now().Before(c.expires) {
+		delete(m.counters, key)
+		return counter{}, false
+	}
+	return c, ok
+}
+
+// set must be called with mu held.
+func (m *memoryStore) set(key string, val int, ttl time.Duration) {
+	m.counters[key] = counter{val: val, expires: m.now().Add(ttl)}
+}
+
+// decr keeps the key's expiry and creates it without one, like DECR. It must
+// be called with mu held.
+func (m *memoryStore) decr(key string) int {
+	c, _ := m.get(key)
+	c.val--
+	m.counters[key] = c
+	return c.val
+}
+
+func (m *memoryStore) DeductQuota(_ context.Context, s Scope, date string) (bool, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var own int
+	for i, scope := range s.Rollups() {
+		v := m.decr(quotaKey(scope, date))
+		if i == 0 {
+			own = v
+		}
+	}
+	m.tenants[s.TenantID] = struct{}{}
+	return own >= 0, own, nil
+}
+
+func (m *memoryStore) ResetQuota(_ context.Context, s Scope, date string, count int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.set(quotaKey(s, date), count, 24*time.Hour)
+	return nil
+}
+
+func (m *memoryStore) InitQuota(_ context.Context, s Scope, date string, base int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := quotaKey(s, date)
+	if _, ok := m.get(key); ok {
+		return false, nil
+	}
+	if a, ok := m.get(allotmentKey(s)); ok {
+		base = a.val
+	}
+	m.set(key, base, 24*time.Hour)
+	return true, nil
+}
+
+func (m *memoryStore) SaveScore(_ context.Context, s Scope, date string, rec ScoreRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rec.Outcomes = slices.Clone(rec.Outcomes)
+	for _, scope := range s.Rollups() {
+		key := scoreKey(scope, date)
+		m.scores[key] = append(m.scores[key], rec)
+	}
+	return nil
+}
+
+func (m *memoryStore) GetScores(_ context.Context, s Scope, date string) ([]ScoreRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.scores[scoreKey(s, date)]), nil
+}
+
+func (m *memoryStore) IncreaseQuota(_ context.Context, s Scope, date string, base int, factor float64, f Fence) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.fence(f); err != nil {
+		return err
+	}
+	if a, ok := m.get(allotmentKey(s)); ok {
+		base = a.val
+	}
+	next := scale(base, factor)
+	m.counters[allotmentKey(s)] = counter{val: next}
+	key := quotaKey(s, date)
+	if c, ok := m.get(key); ok {
+		c.val += next - base
+		m.counters[key] = c
+		return nil
+	}
+	m.set(key, next, 24*time.Hour)
+	return nil
+}
+
+func (m *memoryStore) GetRemainingQuota(_ context.Context, s Scope, date string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, _ := m.get(quotaKey(s, date))
+	return c.val, nil
+}
+
+func (m *memoryStore) SetProviderLimit(_ context.Context, s Scope, p inbox.Provider, date string, limit int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.set(limitKey(s, p, date), limit, 24*time.Hour)
+	return nil
+}
+
+func (m *memoryStore) ProviderLimit(_ context.Context, s Scope, p inbox.Provider, date string) (int, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.get(limitKey(s, p, date))
+	return c.val, ok, nil
+}
+
+func (m *memoryStore) DeductProviderLimit(_ context.Context, s Scope, p inbox.Provider, date string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, scope := range s.Rollups() {
+		key := limitKey(scope, p, date)
+		if _, ok := m.get(key); ok {
+			m.decr(key)
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) SaveReputation(_ context.Context, s Scope, rep Reputation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := s.String()
+	h := m.reputation[key]
+	if n := len(h); n > 0 && h[n-1].Date == rep.Date {
+		h[n-1] = rep
+		return nil
+	}
+	m.reputation[key] = append(h, rep)
+	return nil
+}
+
+func (m *memoryStore) ReputationHistory(_ context.Context, s Scope, limit int) ([]Reputation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.reputation[s.String()]
+	if limit > 0 && len(h) > limit {
+		h = h[len(h)-limit:]
+	}
+	return slices.Clone(h), nil
+}
+
+func (m *memoryStore) Tenants(context.Context) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]string, 0, len(m.tenants))
+	for t := range m.tenants {
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+func (m *memoryStore) SaveDailyReport(_ context.Context, rep DailyReport) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reports[rep.TenantID+":"+rep.Date] = rep
+	return nil
+}
+
+func (m *memoryStore) GetDailyReport(_ context.Context, tenantID, date string) (DailyReport, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rep, ok := m.reports[tenantID+":"+date]
+	return rep, ok, nil
+}
+
+func (m *memoryStore) LastRun(_ context.Context, job string) (time.Time, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	at, ok := m.lastRuns[job]
+	return at, ok, nil
+}
+
+func (m *memoryStore) SetLastRun(_ context.Context, job string, at time.Time, f Fence) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.fence(f); err != nil {
+		return err
+	}
+	m.lastRuns[job] = at.UTC().Truncate(time.Second)
+	return nil
+}
+
+// fence records f's term if it admits the write. The caller holds mu.
+func (m *memoryStore) fence(f Fence) error {
+	if f.Name == "" {
+		return nil
+	}
+	if !f.admits(int64(m.counters[fenceKey(f)].val)) {
+		return ErrFenced
+	}
+	m.counters[fenceKey(f)] = counter{val: int(f.Term)}
+	return nil
+}
@@ -0,0 +1,50 @@
+package quota_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ilivestrong/email_warmup_service/internal/inbox"
+	"github.com/ilivestrong/email_warmup_service/internal/quota"
+	"github.com/ilivestrong/email_warmup_service/internal/quota/quotatest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	quotatest.Run(t, func(t *testing.T) quota.Store { return quota.NewMemoryStore() })
+}
+
+func TestMemoryStoreExpiry(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 1, 2, 9, 0, 0, 0, time.UTC)
+	s := quota.NewMemoryStoreClock(func() time.Time { return now })
+	sc := quota.SenderScope("t1", "alice@acme.com")
+	const date = "2025-01-02"
+
+	if err := s.ResetQuota(ctx, sc, date, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetProviderLimit(ctx, sc, inbox.Gmail, date, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(24*time.Hour - time.Second)
+	if r, _ := s.GetRemainingQuota(ctx, sc, date); r != 10 {
+		t.Fatalf("remaining before expiry = %d, want 10", r)
+	}
+	if _, ok, _ := s.ProviderLimit(ctx, sc, inbox.Gmail, date); !ok {
+		t.Fatal("provider limit expired early")
+	}
+
+	now = now.Add(time.Second)
+	if r, _ := s.GetRemainingQuota(ctx, sc, date); r != 0 {
+		t.Fatalf("remaining after expiry = %d, want 0", r)
+	}
+	if _, ok, _ := s.ProviderLimit(ctx, sc, inbox.Gmail, date); ok {
+		t.Fatal("provider limit did not expire")
+	}
+	created, err := s.InitQuota(ctx, sc, date, 5)
+	if err != nil || !created {
+		t.Fatalf("InitQuota after expiry = %v, %v, want true", created, err)
+	}
+}
@@ -0,0 +1,328 @@
+// Package quotatest is the conformance suite every quota.Store
+// implementation must pass.
+package quotatest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ilivestrong/email_warmup_service/internal/inbox"
+	"github.com/ilivestrong/email_warmup_service/internal/quota"
+)
+
+const date = "2025-01-02"
+
+var (
+	sender = quota.SenderScope("t1", "Alice@Acme.com")
+	domain = quota.DomainScope("t1", "acme.com")
+	tenant = quota.TenantScope("t1")
+)
+
+// Run runs the suite. newStore must return an empty store each call.
+func Run(t *testing.T, newStore func(t *testing.T) quota.Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s quota.Store)
+	}{
+		{"MissingQuotaIsZero", testMissingQuota},
+		{"InitQuotaOnlyOnce", testInitQuota},
+		{"DeductQuotaRollsUp", testDeductQuota},
+		{"ResetQuota", testResetQuota},
+		{"IncreaseQuota", testIncreaseQuota},
+		{"ScoresRollUp", testScores},
+		{"ProviderLimits", testProviderLimits},
+		{"ReputationHistory", testReputation},
+		{"Tenants", testTenants},
+		{"DailyReports", testDailyReports},
+		{"LastRun", testLastRun},
+		{"Fence", testFence},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) { tc.fn(t, newStore(t)) })
+	}
+}
+
+func remaining(t *testing.T, s quota.Store, sc quota.Scope) int {
+	t.Helper()
+	r, err := s.GetRemainingQuota(context.Background(), sc, date)
+	if err != nil {
+		t.Fatalf("GetRemainingQuota(%s): %v", sc, err)
+	}
+	return r
+}
+
+func testMissingQuota(t *testing.T, s quota.Store) {
+	if r := remaining(t, s, sender); r != 0 {
+		t.Fatalf("remaining = %d, want 0", r)
+	}
+}
+
+func testInitQuota(t *testing.T, s quota.Store) {
+	ctx := context.Background()
+	if ok, err := s.InitQuota(ctx, sender, date, 10); err != nil || !ok {
+		t.Fatalf("first InitQuota = %v, %v; want true, nil", ok, err)
+	}
+	if ok, err := s.InitQuota(ctx, sender, date, 99); err != nil || ok {
+		t.Fatalf("second InitQuota = %v, %v; want false, nil", ok, err)
+	}
+	if r := remaining(t, s, sender); r != 10 {
+		t.Fatalf("remaining = %d, want 10", r)
+	}
+}
+
+func testDeductQuota(t *testing.T, s quota.Store) {
+	ctx := context.Background()
+	for sc, n := range map[quota.Scope]int{sender: 1, domain: 5, tenant: 9} {
+		if err := s.ResetQuota(ctx, sc, date, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ok, rem, err := s.DeductQuota(ctx, sender, date)
+	if err != nil || !ok || rem != 0 {
+		t.Fatalf("DeductQuota = %v, %d, %v; want true, 0, nil", ok, rem, err)
+	}
+	if r := remaining(t, s, domain); r != 4 {
+		t.Fatalf("domain remaining = %d, want 4", r)
+	}
+	if r := remaining(t, s, tenant); r != 8 {
+		t.Fatalf("tenant remaining = %d, want 8", r)
+	}
+	ok, rem, err = s.DeductQuota(ctx, sender, date)
+	if err != nil || ok || rem != -1 {
+		t.Fatalf("DeductQuota past zero = %v, %d, %v; want false, -1, nil", ok, rem, err)
+	}
+}
+
+func testResetQuota(t *testing.T, s quota.Store) {
+	ctx := context.Background()
+	s.DeductQuota(ctx, sender, date)
+	if err := s.ResetQuota(ctx, sender, date, 42); err != nil {
+		t.Fatal(err)
+	}
+	if r := remaining(t, s, sender); r != 42 {
+		t.Fatalf("remaining = %d, want 42", r)
+	}
+}
+
+func testIncreaseQuota(t *testing.T, s quota.Store) {
+	ctx := context.Background()
+	if err := s.IncreaseQuota(ctx, sender, date, quota.DefaultDailyQuota, 1.5, quota.Fence{}); err != nil {
+		t.Fatal(err)
+	}
+	if r := remaining(t, s, sender); r != 150 {
+		t.Fatalf("remaining after increasing unset quota = %d, want 150", r)
+	}
+	if ok, err := s.InitQuota(ctx, sender, date, quota.DefaultDailyQuota); err != nil || ok {
+		t.Fatalf("InitQuota after increase = %v, %v; want false, nil", ok, err)
+	}
+
+	// The next day starts from the increased allotment, and an increase
+	// during a started day adds the difference to what is left.
+	const next = "2025-01-03"
+	if ok, err := s.InitQuota(ctx, sender, next, quota.DefaultDailyQuota); err != nil || !ok {
+		t.Fatalf("InitQuota of the next day = %v, %v; want true, nil", ok, err)
+	}
+	s.DeductQuota(ctx, sender, next)
+	if err := s.IncreaseQuota(ctx, sender, next, quota.DefaultDailyQuota, 2, quota.Fence{}); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := s.GetRemainingQuota(ctx, sender, next); err != nil || r != 299 {
+		t.Fatalf("remaining after increasing a started day = %d, %v; want 299", r, err)
+	}
+
+	s.ResetQuota(ctx, domain, date, 10)
+	if err := s.IncreaseQuota(ctx, domain, date, 10, 1.5, quota.Fence{}); err != nil {
+		t.Fatal(err)
+	}
+	if r := remaining(t, s, domain); r != 15 {
+		t.Fatalf("remaining after increase = %d, want 15", r)
+	}
+}
+
+func testScores(t *testing.T, s quota.Store) {
+	ctx := context.Background()
+	if got, err := s.GetScores(ctx, tenant, date); err != nil || len(got) != 0 {
+		t.Fatalf("GetScores on empty store = %v, %v; want none, nil", got, err)
+	}
+	recs := []quota.ScoreRecord{
+		{Provider: inbox.Gmail, Outcomes: []quota.Outcome{quota.Delivered, quota.Opened}, Score: 3},
+		{Provider: inbox.Yahoo, Outcomes: []quota.Outcome{quota.HardBounce}, Score: -2},
+	}
+	for _, rec := range recs {
+		if err := s.SaveScore(ctx, sender, date, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, sc := range []quota.Scope{sender, domain, tenant} {
+		got, err := s.GetScores(ctx, sc, date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(recs) {
+			t.Fatalf("%s has %d scores, want %d", sc, len(got), len(recs))
+		}
+		for i := range recs {
+			if got[i].Provider != recs[i].Provider || got[i].Score != recs[i].Score || !slices.Equal(got[i].Outcomes, recs[i].Outcomes) {
+				t.Fatalf("%s score %d = %+v, want %+v", sc, i, got[i], recs[i])
+			}
+		}
+	}
+	if got, _ := s.GetScores(ctx, quota.SenderScope("t1", "bob@acme.com"), date); len(got) != 0 {
+		t.Fatalf("unrelated sender has %d scores", len(got))
+	}
+}
+
+func testProviderLimits(t *testing.T, s quota.Store) {
+	ctx := context.Background()
+	if _, limited, err := s.ProviderLimit(ctx, sender, inbox.Gmail, date); err != nil || limited {
+		t.Fatalf("ProviderLimit before throttling = %v, %v; want false, nil", limited, err)
+	}
+	if err := s.DeductProviderLimit(ctx, sender, inbox.Gmail, date); err != nil {
+		t.Fatal(err)
+	}
+	if _, limited, _ := s.ProviderLimit(ctx, sender, inbox.Gmail, date); limited {
+		t.Fatal("deducting an unset limit created one")
+	}
+	if err := s.SetProviderLimit(ctx, domain, inbox.Gmail, date, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeductProviderLimit(ctx, sender, inbox.Gmail, date); err != nil {
+		t.Fatal(err)
+	}
+	if n, limited, _ := s.ProviderLimit(ctx, domain, inbox.Gmail, date); !limited || n != 2 {
+		t.Fatalf("domain gmail limit = %d, %v; want 2, true", n, limited)
+	}
+	if _, limited, _ := s.ProviderLimit(ctx, domain, inbox.Outlook, date); limited {
+		t.Fatal("throttling gmail limited outlook")
+	}
+}
+
+func testReputation(t *testing.T, s quota.Store) {
+	ctx := context.Background()
+	if h, err := s.ReputationHistory(ctx, sender, 0); err != nil || len(h) != 0 {
+		t.Fatalf("empty history = %v, %v", h, err)
+	}
+	points := []quota.Reputation{
+		{Date: "2025-01-01", Score: 1, Weight: 10},
+		{Date: "2025-01-02", Score: 2, Weight: 20},
+		{Date: "2025-01-02", Score: 3, Weight: 30}, // replaces the previous point
+		{Date: "2025-01-03", Score: 4, Weight: 40},
+	}
+	for _, p := range points {
+		if err := s.SaveReputation(ctx, sender, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h, err := s.ReputationHistory(ctx, sender, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{1, 3, 4}
+	if len(h) != len(want) {
+		t.Fatalf("history has %d points, want %d", len(h), len(want))
+	}
+	for i, p := range h {
+		if p.Score != want[i] {
+			t.Fatalf("point %d score = %v, want %v", i, p.Score, want[i])
+		}
+	}
+	h, _ = s.ReputationHistory(ctx, sender, 2)
+	if len(h) != 2 || h[0].Date != "2025-01-02" || h[1].Date != "2025-01-03" {
+		t.Fatalf("limited history = %+v, want the latest two points oldest first", h)
+	}
+}
+
+func testTenants(t *testing.T, s quota.Store) {
+	ctx := context.Background()
+	s.DeductQuota(ctx, sender, date)
+	s.DeductQuota(ctx, quota.SenderScope("t2", "x@y.com"), date)
+	s.DeductQuota(ctx, sender, date)
+	got, err := s.Tenants(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, []string{"t1", "t2"}) {
+		t.Fatalf("Tenants = %v, want [t1 t2]", got)
+	}
+}
+
+func testDailyReports(t *testing.T, s quota.Store) {
+	ctx := context.Background()
+	if _, ok, err := s.GetDailyReport(ctx, "t1", date); err != nil || ok {
+		t.Fatalf("missing report = %v, %v; want false, nil", ok, err)
+	}
+	rep := quota.DailyReport{TenantID: "t1", Date: date, Scopes: []quota.ScopeReport{{
+		Scope:    tenant.String(),
+		Sends:    2,
+		Outcomes: map[quota.Outcome]int{quota.Delivered: 2},
+		Decision: quota.DecisionHold,
+		Reason:   "below threshold",
+	}}}
+	if err := s.SaveDailyReport(ctx, rep); err != nil {
+		t.Fatal(err)
+	}
+	got, ok, err := s.GetDailyReport(ctx, "t1", date)
+	if err != nil || !ok {
+		t.Fatalf("GetDailyReport = %v, %v", ok, err)
+	}
+	if len(got.Scopes) != 1 || got.Scopes[0].Decision != quota.DecisionHold || got.Scopes[0].Outcomes[quota.Delivered] != 2 {
+		t.Fatalf("report = %+v, want %+v", got, rep)
+	}
+}
+
+func testLastRun(t *testing.T, s quota.Store) {
+	ctx := context.Background()
+	if _, ok, err := s.LastRun(ctx, "job"); err != nil || ok {
+		t.Fatalf("LastRun before any run = %v, %v; want false, nil", ok, err)
+	}
+	at := time.Date(2025, 1, 2, 0, 0, 0, 0, time.FixedZone("X", 3600))
+	if err := s.SetLastRun(ctx, "job", at, quota.Fence{}); err != nil {
+		t.Fatal(err)
+	}
+	got, ok, err := s.LastRun(ctx, "job")
+	if err != nil || !ok || !got.Equal(at) {
+		t.Fatalf("LastRun = %v, %v, %v; want %v", got, ok, err, at)
+	}
+}
+
+func testFence(t *testing.T, s quota.Store) {
+	ctx := context.Background()
+	at := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+	fence := func(term int64) quota.Fence { return quota.Fence{Name: "scheduler", Term: term} }
+
+	if err := s.SetLastRun(ctx, "job", at, fence(0)); !errors.Is(err, quota.ErrFenced) {
+		t.Fatalf("SetLastRun outside a term = %v, want ErrFenced", err)
+	}
+	if err := s.SetLastRun(ctx, "job", at, fence(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetLastRun(ctx, "job", at.Add(time.Hour), fence(2)); err != nil {
+		t.Fatalf("SetLastRun in the same term: %v", err)
+	}
+	if err := s.SetLastRun(ctx, "job", at.Add(2*time.Hour), fence(1)); !errors.Is(err, quota.ErrFenced) {
+		t.Fatalf("SetLastRun from an older term = %v, want ErrFenced", err)
+	}
+	if got, _, _ := s.LastRun(ctx, "job"); !got.Equal(at.Add(time.Hour)) {
+		t.Fatalf("LastRun = %v, want the write from term 2", got)
+	}
+
+	if err := s.IncreaseQuota(ctx, sender, date, 10, 2, fence(1)); !errors.Is(err, quota.ErrFenced) {
+		t.Fatalf("IncreaseQuota from an older term = %v, want ErrFenced", err)
+	}
+	if r := remaining(t, s, sender); r != 0 {
+		t.Fatalf("fenced IncreaseQuota left %d, want nothing written", r)
+	}
+	if err := s.IncreaseQuota(ctx, sender, date, 10, 2, fence(3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetLastRun(ctx, "job", at, fence(2)); !errors.Is(err, quota.ErrFenced) {
+		t.Fatalf("SetLastRun after a newer term wrote = %v, want ErrFenced", err)
+	}
+	if err := s.SetLastRun(ctx, "job", at, quota.Fence{}); err != nil {
+		t.Fatalf("unfenced SetLastRun: %v", err)
+	}
+}
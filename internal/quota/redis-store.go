@@ -2,18 +2,74 @@ package quota
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/ilivestrong/email_warmup_service/internal/inbox"
 )
 
+// decrIfExists only spends limits that were actually set.
+var decrIfExists = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return redis.call("DECR", KEYS[1])
+end
+return nil`)
+
+// initQuota starts the day (KEYS[2]) with the allotment (KEYS[1]), or the
+// base in ARGV[1] while there is none, unless the day has already started.
+var initQuota = redis.NewScript(`
+local base = redis.call("GET", KEYS[1]) or ARGV[1]
+if redis.call("SET", KEYS[2], base, "NX", "PX", ARGV[2]) then
+	return 1
+end
+return 0`)
+
+// fenceCheck starts fenced scripts. They take the fence key as KEYS[1] and
+// the fence name and term as ARGV[1] and ARGV[2]; see Fence.admits.
+const fenceCheck = `
+if ARGV[1] ~= "" then
+	local seen = tonumber(redis.call("GET", KEYS[1]) or "0")
+	local term = tonumber(ARGV[2])
+	if term <= 0 or term < seen then
+		return redis.error_reply("fenced")
+	end
+	redis.call("SET", KEYS[1], term)
+end
+`
+
+// increaseQuota scales the allotment (KEYS[2]) by ARGV[4], starting from the
+// base in ARGV[3], and carries the difference into the day (KEYS[3]).
+var increaseQuota = redis.NewScript(fenceCheck + `
+local base = tonumber(redis.call("GET", KEYS[2]) or ARGV[3])
+local next = math.floor(base * tonumber(ARGV[4]))
+redis.call("SET", KEYS[2], next)
+if redis.call("EXISTS", KEYS[3]) == 1 then
+	redis.call("INCRBY", KEYS[3], next - base)
+else
+	redis.call("SET", KEYS[3], next, "PX", ARGV[5])
+end
+return next`)
+
+// setLastRun stores ARGV[3] under KEYS[2].
+var setLastRun = redis.NewScript(fenceCheck + `
+return redis.call("SET", KEYS[2], ARGV[3])`)
+
+// fenced maps the error reply of fenceCheck to ErrFenced.
+func fenced(err error) error {
+	if err != nil && err.Error() == "fenced" {
+		return ErrFenced
+	}
+	return err
+}
+
 type redisStore struct {
 	rdb *redis.Client
 }
 
-func NewStore(redisURL string) (Store, error) {
+func newRedisStore(redisURL string) (Store, error) {
 	opts, err := redis.ParseURL(redisURL)
 	if err != nil {
 		return nil, err
@@ -21,12 +77,16 @@ func NewStore(redisURL string) (Store, error) {
 	return &redisStore{rdb: redis.NewClient(opts)}, nil
 }
 
-func (r *redisStore) key(t string, d time.Time) string {
-	return fmt.Sprintf("quota:%s:%s", t, d.Format("2006-01-02"))
+// tenantsKey holds every tenant that has spent quota.
+const tenantsKey = "tenants"
+
+// reputationKey has no date and no TTL: the history is kept for auditing.
+func (r *redisStore) reputationKey(s Scope) string {
+	return fmt.Sprintf("reputation:%s", s)
 }
 
-func (r *redisStore) GetRemainingQuota(ctx context.Context, t string, d time.Time) (int, error) {
-	v, err := r.rdb.Get(ctx, r.key(t, d)).Result()
+func (r *redisStore) GetRemainingQuota(ctx context.Context, s Scope, date string) (int, error) {
+	v, err := r.rdb.Get(ctx, quotaKey(s, date)).Result()
 	if err == redis.Nil {
 		return 0, nil
 	}
@@ -40,52 +100,185 @@ func (r *redisStore) GetRemainingQuota(ctx context.Context, t string, d time.Tim
 	return q, nil
 }
 
-func (r *redisStore) DeductQuota(ctx context.Context, tenantID string, date string) (bool, int, error) {
-	key := fmt.Sprintf("quota:%s:%s", tenantID, date)
-	res := r.rdb.Decr(ctx, key)
-	val, err := res.Result()
+func (r *redisStore) DeductQuota(ctx context.Context, s Scope, date string) (bool, int, error) {
+	var own *redis.IntCmd
+	_, err := r.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, scope := range s.Rollups() {
+			cmd := pipe.Decr(ctx, quotaKey(scope, date))
+			if i == 0 {
+				own = cmd
+			}
+		}
+		pipe.SAdd(ctx, tenantsKey, s.TenantID)
+		return nil
+	})
 	if err != nil {
 		return false, 0, err
 	}
+	val := own.Val()
 	return val >= 0, int(val), nil
 }
 
-func (r *redisStore) ResetQuota(ctx context.Context, tenantID string, date string, count int) error {
-	key := fmt.Sprintf("quota:%s:%s", tenantID, date)
-	return r.rdb.Set(ctx, key, count, 24*time.Hour).Err()
+func (r *redisStore) ResetQuota(ctx context.Context, s Scope, date string, count int) error {
+	return r.rdb.Set(ctx, quotaKey(s, date), count, 24*time.Hour).Err()
 }
 
-func (r *redisStore) SaveScore(ctx context.Context, tenantID, date string, score int) error {
-	key := fmt.Sprintf("score:%s:%s", tenantID, date)
-	return r.rdb.RPush(ctx, key, score).Err()
+func (r *redisStore) InitQuota(ctx context.Context, s Scope, date string, base int) (bool, error) {
+	created, err := initQuota.Run(ctx, r.rdb, []string{allotmentKey(s), quotaKey(s, date)},
+		base, (24 * time.Hour).Milliseconds()).Int()
+	return created == 1, err
 }
 
-func (r *redisStore) GetScores(ctx context.Context, tenantID, date string) ([]int, error) {
-	key := fmt.Sprintf("score:%s:%s", tenantID, date)
-	vals, err := r.rdb.LRange(ctx, key, 0, -1).Result()
+func (r *redisStore) SaveScore(ctx context.Context, s Scope, date string, rec ScoreRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = r.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, scope := range s.Rollups() {
+			pipe.RPush(ctx, scoreKey(scope, date), b)
+		}
+		return nil
+	})
+	return err
+}
+
+func (r *redisStore) GetScores(ctx context.Context, s Scope, date string) ([]ScoreRecord, error) {
+	vals, err := r.rdb.LRange(ctx, scoreKey(s, date), 0, -1).Result()
 	if err != nil {
 		return nil, err
 	}
-	var out []int
+	var out []ScoreRecord
 	for _, v := range vals {
-		val, err := strconv.Atoi(v)
+		rec, err := decodeScore(v)
 		if err == nil {
-			out = append(out, val)
+			out = append(out, rec)
 		}
 	}
 	return out, nil
 }
 
-func (r *redisStore) IncreaseQuota(ctx context.Context, tenantID, date string) error {
-	today := time.Now().Format("2006-01-02")
-	key := fmt.Sprintf("quota:%s:%s", tenantID, today)
-	cur, err := r.rdb.Get(ctx, key).Int()
+// IncreaseQuota scales the allotment and the quota of the given tenant-local
+// day in one script, so that concurrent deductions are not lost.
+func (r *redisStore) IncreaseQuota(ctx context.Context, s Scope, date string, base int, factor float64, f Fence) error {
+	return fenced(increaseQuota.Run(ctx, r.rdb, []string{fenceKey(f), allotmentKey(s), quotaKey(s, date)},
+		f.Name, f.Term, base, factor, (24 * time.Hour).Milliseconds()).Err())
+}
+
+func (r *redisStore) SetProviderLimit(ctx context.Context, s Scope, p inbox.Provider, date string, limit int) error {
+	return r.rdb.Set(ctx, limitKey(s, p, date), limit, 24*time.Hour).Err()
+}
+
+func (r *redisStore) ProviderLimit(ctx context.Context, s Scope, p inbox.Provider, date string) (int, bool, error) {
+	v, err := r.rdb.Get(ctx, limitKey(s, p, date)).Int()
+	if err == redis.Nil {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return v, true, nil
+}
+
+func (r *redisStore) DeductProviderLimit(ctx context.Context, s Scope, p inbox.Provider, date string) error {
+	for _, scope := range s.Rollups() {
+		err := decrIfExists.Run(ctx, r.rdb, []string{limitKey(scope, p, date)}).Err()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *redisStore) SaveReputation(ctx context.Context, s Scope, rep Reputation) error {
+	key := r.reputationKey(s)
+	b, err := json.Marshal(rep)
+	if err != nil {
+		return err
+	}
+	last, err := r.rdb.LIndex(ctx, key, -1).Result()
 	if err != nil && err != redis.Nil {
 		return err
 	}
-	if cur == 0 {
-		cur = 5 // Default quota if not set yet
+	if err == nil {
+		var prev Reputation
+		if json.Unmarshal([]byte(last), &prev) == nil && prev.Date == rep.Date {
+			return r.rdb.LSet(ctx, key, -1, b).Err()
+		}
+	}
+	return r.rdb.RPush(ctx, key, b).Err()
+}
+
+func (r *redisStore) ReputationHistory(ctx context.Context, s Scope, limit int) ([]Reputation, error) {
+	start := int64(0)
+	if limit > 0 {
+		start = -int64(limit)
+	}
+	vals, err := r.rdb.LRange(ctx, r.reputationKey(s), start, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Reputation, 0, len(vals))
+	for _, v := range vals {
+		var rep Reputation
+		if err := json.Unmarshal([]byte(v), &rep); err == nil {
+			out = append(out, rep)
+		}
+	}
+	return out, nil
+}
+
+func (r *redisStore) Tenants(ctx context.Context) ([]string, error) {
+	return r.rdb.SMembers(ctx, tenantsKey).Result()
+}
+
+func (r *redisStore) reportKey(tenantID, date string) string {
+	return fmt.Sprintf("report:%s:%s", tenantID, date)
+}
+
+func (r *redisStore) SaveDailyReport(ctx context.Context, rep DailyReport) error {
+	b, err := json.Marshal(rep)
+	if err != nil {
+		return err
+	}
+	return r.rdb.Set(ctx, r.reportKey(rep.TenantID, rep.Date), b, 0).Err()
+}
+
+func (r *redisStore) GetDailyReport(ctx context.Context, tenantID, date string) (DailyReport, bool, error) {
+	var rep DailyReport
+	b, err := r.rdb.Get(ctx, r.reportKey(tenantID, date)).Bytes()
+	if err == redis.Nil {
+		return rep, false, nil
+	}
+	if err != nil {
+		return rep, false, err
+	}
+	if err := json.Unmarshal(b, &rep); err != nil {
+		return rep, false, err
+	}
+	return rep, true, nil
+}
+
+func (r *redisStore) lastRunKey(job string) string {
+	return fmt.Sprintf("scheduler:lastrun:%s", job)
+}
+
+func (r *redisStore) LastRun(ctx context.Context, job string) (time.Time, bool, error) {
+	v, err := r.rdb.Get(ctx, r.lastRunKey(job)).Result()
+	if err == redis.Nil {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
 	}
-	newQuota := int(float64(cur) * 1.5)
-	return r.rdb.Set(ctx, key, newQuota, 24*time.Hour).Err()
+	at, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return at, true, nil
+}
+
+func (r *redisStore) SetLastRun(ctx context.Context, job string, at time.Time, f Fence) error {
+	return fenced(setLastRun.Run(ctx, r.rdb, []string{fenceKey(f), r.lastRunKey(job)},
+		f.Name, f.Term, at.UTC().Format(time.RFC3339)).Err())
 }
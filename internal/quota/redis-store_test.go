@@ -0,0 +1,37 @@
+package quota_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/ilivestrong/email_warmup_service/internal/quota"
+	"github.com/ilivestrong/email_warmup_service/internal/quota/quotatest"
+)
+
+// TestRedisStore runs against REDIS_URL and flushes its database before every
+// case, so it must point at a scratch database.
+func TestRedisStore(t *testing.T) {
+	url := os.Getenv("REDIS_URL")
+	if url == "" {
+		t.Skip("REDIS_URL not set")
+	}
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdb := redis.NewClient(opts)
+	t.Cleanup(func() { rdb.Close() })
+
+	quotatest.Run(t, func(t *testing.T) quota.Store {
+		if err := rdb.FlushDB(context.Background()).Err(); err != nil {
+			t.Fatal(err)
+		}
+		s, err := quota.NewStore(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
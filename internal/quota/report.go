@@ -0,0 +1,34 @@
+package quota
+
+import "github.com/ilivestrong/email_warmup_service/internal/inbox"
+
+// Decision is what the daily job did with a scope's quota.
+type Decision string
+
+const (
+	DecisionIncrease         Decision = "increase"
+	DecisionHold             Decision = "hold"
+	DecisionInsufficientData Decision = "insufficient data"
+	DecisionFailed           Decision = "failed"
+)
+
+// DailyReport is the outcome of the daily job for one tenant and one
+// tenant-local day. Scopes starts with the tenant rollup, followed by its
+// sending domains and sender mailboxes.
+type DailyReport struct {
+	TenantID string        `json:"tenantId"`
+	Date     string        `json:"date"`
+	Scopes   []ScopeReport `json:"scopes"`
+}
+
+type ScopeReport struct {
+	Scope            string                 `json:"scope"`
+	Sends            int                    `json:"sends"`
+	Outcomes         map[Outcome]int        `json:"outcomes"`
+	Average          float64                `json:"average"`
+	Reputation       float64                `json:"reputation"`
+	ReputationWeight float64                `json:"reputationWeight"`
+	Decision         Decision               `json:"decision"`
+	Reason           string                 `json:"reason"`
+	Throttled        map[inbox.Provider]int `json:"throttled,omitempty"`
+}
@@ -0,0 +1,12 @@
+package quota
+
+// Reputation is one day's point of a scope's rolling reputation. Score is the
+// exponentially weighted moving average of daily scores and Weight the
+// decayed number of sends backing it.
+type Reputation struct {
+	Date       string  `json:"date"`
+	Score      float64 `json:"score"`
+	Weight     float64 `json:"weight"`
+	DayAverage float64 `json:"dayAverage"`
+	DaySamples int     `json:"daySamples"`
+}
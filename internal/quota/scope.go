@@ -0,0 +1,72 @@
+package quota
+
+import "strings"
+
+// Scope identifies who a quota or score belongs to: a whole tenant, one of
+// its sending domains, or a single sender mailbox.
+type Scope struct {
+	TenantID string
+	Domain   string
+	Sender   string
+}
+
+func TenantScope(tenantID string) Scope { return Scope{TenantID: tenantID} }
+
+func DomainScope(tenantID, domain string) Scope {
+	return Scope{TenantID: tenantID, Domain: strings.ToLower(domain)}
+}
+
+func SenderScope(tenantID, sender string) Scope {
+	sender = strings.ToLower(sender)
+	s := Scope{TenantID: tenantID, Sender: sender}
+	if i := strings.LastIndex(sender, "@"); i >= 0 {
+		s.Domain = sender[i+1:]
+	}
+	return s
+}
+
+// String is the scope's key segment: "tenant", "tenant:@domain" or
+// "tenant:sender@domain".
+func (s Scope) String() string {
+	switch {
+	case s.Sender != "":
+		return s.TenantID + ":" + s.Sender
+	case s.Domain != "":
+		return s.TenantID + ":@" + s.Domain
+	}
+	return s.TenantID
+}
+
+// Rollups returns s followed by every broader scope that aggregates it.
+func (s Scope) Rollups() []Scope {
+	out := []Scope{s}
+	if s.Sender != "" && s.Domain != "" {
+		out = append(out, DomainScope(s.TenantID, s.Domain))
+	}
+	if s.Sender != "" || s.Domain != "" {
+		out = append(out, TenantScope(s.TenantID))
+	}
+	return out
+}
+
+// DefaultDailyQuota is the quota a sender mailbox starts a day with. Domains
+// and tenants start with the sum of their senders' quotas.
+const DefaultDailyQuota = 100
+
+// InitialQuota returns the day-start quota of each scope of a tenant that
+// sends from the given addresses.
+func InitialQuota(senders []string) func(Scope) int {
+	perDomain := map[string]int{}
+	for _, addr := range senders {
+		perDomain[SenderScope("", addr).Domain]++
+	}
+	return func(s Scope) int {
+		switch {
+		case s.Sender != "":
+			return DefaultDailyQuota
+		case s.Domain != "":
+			return DefaultDailyQuota * perDomain[s.Domain]
+		}
+		return DefaultDailyQuota * len(senders)
+	}
+}
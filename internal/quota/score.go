@@ -0,0 +1,95 @@
+package quota
+
+import (
+	"encoding/json"
+	"slices"
+	"strconv"
+
+	"github.com/ilivestrong/email_warmup_service/internal/inbox"
+)
+
+// Outcome is one observable dimension of how a send performed.
+type Outcome string
+
+const (
+	Delivered  Outcome = "delivered"
+	SoftBounce Outcome = "soft_bounce"
+	HardBounce Outcome = "hard_bounce"
+	Opened     Outcome = "opened"
+	Replied    Outcome = "replied"
+	Spam       Outcome = "spam"
+	Rescued    Outcome = "rescued" // moved out of spam by a seed mailbox
+)
+
+// Outcomes lists every known outcome dimension.
+var Outcomes = []Outcome{Delivered, SoftBounce, HardBounce, Opened, Replied, Spam, Rescued}
+
+// ScoreRecord is the structured result of a single send, tagged with the
+// recipient's mailbox provider. Score is the value the scoring model gave it
+// when it was recorded; averages are recomputed from Outcomes. A Followup
+// record adds outcomes observed after the send, such as a rescue from spam,
+// and does not count as a send of its own.
+type ScoreRecord struct {
+	Provider inbox.Provider `json:"provider"`
+	Outcomes []Outcome      `json:"outcomes"`
+	Score    float64        `json:"score"`
+	Followup bool           `json:"followup,omitempty"`
+}
+
+func (r ScoreRecord) Has(o Outcome) bool { return slices.Contains(r.Outcomes, o) }
+
+// decodeScore reads a stored record. Bare integers written before records
+// were structured decode as a score without outcomes.
+func decodeScore(v string) (ScoreRecord, error) {
+	if n, err := strconv.Atoi(v); err == nil {
+		return ScoreRecord{Score: float64(n)}, nil
+	}
+	var rec ScoreRecord
+	err := json.Unmarshal([]byte(v), &rec)
+	return rec, err
+}
+
+// ProviderStats aggregates score records sent to one mailbox provider.
+type ProviderStats struct {
+	Sent      int
+	Delivered int
+	Opened    int
+	Spam      int
+}
+
+func (s ProviderStats) DeliveryRate() float64 { return rate(s.Delivered, s.Sent) }
+func (s ProviderStats) OpenRate() float64     { return rate(s.Opened, s.Sent) }
+func (s ProviderStats) SpamRate() float64     { return rate(s.Spam, s.Sent) }
+
+func rate(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+// ByProvider breaks records down per recipient mailbox provider. Records
+// without a provider are left out.
+func ByProvider(recs []ScoreRecord) map[inbox.Provider]ProviderStats {
+	out := map[inbox.Provider]ProviderStats{}
+	for _, rec := range recs {
+		if rec.Provider == "" {
+			continue
+		}
+		st := out[rec.Provider]
+		if !rec.Followup {
+			st.Sent++
+		}
+		if rec.Has(Delivered) {
+			st.Delivered++
+		}
+		if rec.Has(Opened) {
+			st.Opened++
+		}
+		if rec.Has(Spam) {
+			st.Spam++
+		}
+		out[rec.Provider] = st
+	}
+	return out
+}
@@ -0,0 +1,395 @@
+package quota
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/ilivestrong/email_warmup_service/internal/inbox"
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS counters (
+	key        TEXT PRIMARY KEY,
+	value      INTEGER NOT NULL,
+	expires_at INTEGER -- unix milliseconds, NULL never expires
+);
+CREATE TABLE IF NOT EXISTS scores (
+	id     INTEGER PRIMARY KEY AUTOINCREMENT,
+	scope  TEXT NOT NULL,
+	date   TEXT NOT NULL,
+	record TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS scores_scope_date ON scores (scope, date);
+CREATE TABLE IF NOT EXISTS reputation (
+	scope  TEXT NOT NULL,
+	date   TEXT NOT NULL,
+	record TEXT NOT NULL,
+	PRIMARY KEY (scope, date)
+);
+CREATE TABLE IF NOT EXISTS tenants (tenant_id TEXT PRIMARY KEY);
+CREATE TABLE IF NOT EXISTS daily_reports (
+	tenant_id TEXT NOT NULL,
+	date      TEXT NOT NULL,
+	report    TEXT NOT NULL,
+	PRIMARY KEY (tenant_id, date)
+);
+CREATE TABLE IF NOT EXISTS job_runs (job TEXT PRIMARY KEY, last_run TEXT NOT NULL);
+`
+
+// sqliteStore is an embedded store for deployments without Redis. Quotas and
+// provider limits expire like their Redis keys; scores, reputation and
+// reports are kept for good.
+type sqliteStore struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func newSQLiteStore(path string) (Store, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// A single connection serialises writers, which SQLite requires anyway,
+	// and keeps ":memory:" databases from being per-connection.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteStore{db: db, now: time.Now}, nil
+}
+
+func (s *sqliteStore) tx(ctx context.Context, fn func(q querier) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) get(ctx context.Context, q querier, key string) (int, bool, error) {
+	var val int
+	var expires sql.NullInt64
+	err := q.QueryRowContext(ctx, `SELECT value, expires_at FROM counters WHERE key = ?`, key).Scan(&val, &expires)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if expires.Valid && s.now().UnixMilli() >= expires.Int64 {
+		_, err := q.ExecContext(ctx, `DELETE FROM counters WHERE key = ?`, key)
+		return 0, false, err
+	}
+	return val, true, nil
+}
+
+// set stores the counter; a ttl of zero or less never expires.
+func (s *sqliteStore) set(ctx context.Context, q querier, key string, val int, ttl time.Duration) error {
+	var expires sql.NullInt64
+	if ttl > 0 {
+		expires = sql.NullInt64{Int64: s.now().Add(ttl).UnixMilli(), Valid: true}
+	}
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO counters (key, value, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at`,
+		key, val, expires)
+	return err
+}
+
+// decr keeps the key's expiry and creates it without one, like DECR.
+func (s *sqliteStore) decr(ctx context.Context, q querier, key string) (int, error) {
+	val, ok, err := s.get(ctx, q, key)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		_, err = q.ExecContext(ctx, `UPDATE counters SET value = value - 1 WHERE key = ?`, key)
+	} else {
+		_, err = q.ExecContext(ctx, `INSERT INTO counters (key, value, expires_at) VALUES (?, -1, NULL)`, key)
+	}
+	return val - 1, err
+}
+
+func (s *sqliteStore) DeductQuota(ctx context.Context, sc Scope, date string) (bool, int, error) {
+	var own int
+	err := s.tx(ctx, func(q querier) error {
+		for i, scope := range sc.Rollups() {
+			v, err := s.decr(ctx, q, quotaKey(scope, date))
+			if err != nil {
+				return err
+			}
+			if i == 0 {
+				own = v
+			}
+		}
+		_, err := q.ExecContext(ctx, `INSERT OR IGNORE INTO tenants (tenant_id) VALUES (?)`, sc.TenantID)
+		return err
+	})
+	if err != nil {
+		return false, 0, err
+	}
+	return own >= 0, own, nil
+}
+
+func (s *sqliteStore) ResetQuota(ctx context.Context, sc Scope, date string, count int) error {
+	return s.set(ctx, s.db, quotaKey(sc, date), count, 24*time.Hour)
+}
+
+func (s *sqliteStore) InitQuota(ctx context.Context, sc Scope, date string, base int) (bool, error) {
+	created := false
+	err := s.tx(ctx, func(q querier) error {
+		key := quotaKey(sc, date)
+		_, ok, err := s.get(ctx, q, key)
+		if err != nil || ok {
+			return err
+		}
+		a, ok, err := s.get(ctx, q, allotmentKey(sc))
+		if err != nil {
+			return err
+		}
+		if ok {
+			base = a
+		}
+		created = true
+		return s.set(ctx, q, key, base, 24*time.Hour)
+	})
+	return created, err
+}
+
+func (s *sqliteStore) SaveScore(ctx context.Context, sc Scope, date string, rec ScoreRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return s.tx(ctx, func(q querier) error {
+		for _, scope := range sc.Rollups() {
+			_, err := q.ExecContext(ctx, `INSERT INTO scores (scope, date, record) VALUES (?, ?, ?)`, scope.String(), date, string(b))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqliteStore) GetScores(ctx context.Context, sc Scope, date string) ([]ScoreRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT record FROM scores WHERE scope = ? AND date = ? ORDER BY id`, sc.String(), date)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []ScoreRecord
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		if rec, err := decodeScore(v); err == nil {
+			out = append(out, rec)
+		}
+	}
+	return out, rows.Err()
+}
+
+func (s *sqliteStore) IncreaseQuota(ctx context.Context, sc Scope, date string, base int, factor float64, f Fence) error {
+	return s.tx(ctx, func(q querier) error {
+		if err := s.fence(ctx, q, f); err != nil {
+			return err
+		}
+		a, ok, err := s.get(ctx, q, allotmentKey(sc))
+		if err != nil {
+			return err
+		}
+		if ok {
+			base = a
+		}
+		next := scale(base, factor)
+		if err := s.set(ctx, q, allotmentKey(sc), next, 0); err != nil {
+			return err
+		}
+		key := quotaKey(sc, date)
+		_, ok, err = s.get(ctx, q, key)
+		if err != nil {
+			return err
+		}
+		if ok {
+			_, err = q.ExecContext(ctx, `UPDATE counters SET value = value + ? WHERE key = ?`, next-base, key)
+			return err
+		}
+		return s.set(ctx, q, key, next, 24*time.Hour)
+	})
+}
+
+func (s *sqliteStore) GetRemainingQuota(ctx context.Context, sc Scope, date string) (int, error) {
+	v, _, err := s.get(ctx, s.db, quotaKey(sc, date))
+	return v, err
+}
+
+func (s *sqliteStore) SetProviderLimit(ctx context.Context, sc Scope, p inbox.Provider, date string, limit int) error {
+	return s.set(ctx, s.db, limitKey(sc, p, date), limit, 24*time.Hour)
+}
+
+func (s *sqliteStore) ProviderLimit(ctx context.Context, sc Scope, p inbox.Provider, date string) (int, bool, error) {
+	return s.get(ctx, s.db, limitKey(sc, p, date))
+}
+
+func (s *sqliteStore) DeductProviderLimit(ctx context.Context, sc Scope, p inbox.Provider, date string) error {
+	return s.tx(ctx, func(q querier) error {
+		for _, scope := range sc.Rollups() {
+			key := limitKey(scope, p, date)
+			_, ok, err := s.get(ctx, q, key)
+			if err != nil {
+				return err
+			}
+			if ok {
+				if _, err := s.decr(ctx, q, key); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqliteStore) SaveReputation(ctx context.Context, sc Scope, rep Reputation) error {
+	b, err := json.Marshal(rep)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO reputation (scope, date, record) VALUES (?, ?, ?)
+		ON CONFLICT (scope, date) DO UPDATE SET record = excluded.record`,
+		sc.String(), rep.Date, string(b))
+	return err
+}
+
+func (s *sqliteStore) ReputationHistory(ctx context.Context, sc Scope, limit int) ([]Reputation, error) {
+	if limit <= 0 {
+		limit = -1 // no limit
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT record FROM (
+			SELECT date, record FROM reputation WHERE scope = ? ORDER BY date DESC LIMIT ?
+		) ORDER BY date`, sc.String(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Reputation{}
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		var rep Reputation
+		if err := json.Unmarshal([]byte(v), &rep); err == nil {
+			out = append(out, rep)
+		}
+	}
+	return out, rows.Err()
+}
+
+func (s *sqliteStore) Tenants(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT tenant_id FROM tenants ORDER BY tenant_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqliteStore) SaveDailyReport(ctx context.Context, rep DailyReport) error {
+	b, err := json.Marshal(rep)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO daily_reports (tenant_id, date, report) VALUES (?, ?, ?)
+		ON CONFLICT (tenant_id, date) DO UPDATE SET report = excluded.report`,
+		rep.TenantID, rep.Date, string(b))
+	return err
+}
+
+func (s *sqliteStore) GetDailyReport(ctx context.Context, tenantID, date string) (DailyReport, bool, error) {
+	var rep DailyReport
+	var v string
+	err := s.db.QueryRowContext(ctx, `SELECT report FROM daily_reports WHERE tenant_id = ? AND date = ?`, tenantID, date).Scan(&v)
+	if errors.Is(err, sql.ErrNoRows) {
+		return rep, false, nil
+	}
+	if err != nil {
+		return rep, false, err
+	}
+	if err := json.Unmarshal([]byte(v), &rep); err != nil {
+		return rep, false, err
+	}
+	return rep, true, nil
+}
+
+func (s *sqliteStore) LastRun(ctx context.Context, job string) (time.Time, bool, error) {
+	var v string
+	err := s.db.QueryRowContext(ctx, `SELECT last_run FROM job_runs WHERE job = ?`, job).Scan(&v)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	at, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return at, true, nil
+}
+
+func (s *sqliteStore) SetLastRun(ctx context.Context, job string, at time.Time, f Fence) error {
+	return s.tx(ctx, func(q querier) error {
+		if err := s.fence(ctx, q, f); err != nil {
+			return err
+		}
+		_, err := q.ExecContext(ctx, `
+			INSERT INTO job_runs (job, last_run) VALUES (?, ?)
+			ON CONFLICT (job) DO UPDATE SET last_run = excluded.last_run`,
+			job, at.UTC().Format(time.RFC3339))
+		return err
+	})
+}
+
+// fence records f's term if it admits the write, within the caller's
+// transaction.
+func (s *sqliteStore) fence(ctx context.Context, q querier, f Fence) error {
+	if f.Name == "" {
+		return nil
+	}
+	seen, _, err := s.get(ctx, q, fenceKey(f))
+	if err != nil {
+		return err
+	}
+	if !f.admits(int64(seen)) {
+		return ErrFenced
+	}
+	return s.set(ctx, q, fenceKey(f), int(f.Term), 0)
+}
@@ -0,0 +1,19 @@
+package quota_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/ilivestrong/email_warmup_service/internal/quota"
+	"github.com/ilivestrong/email_warmup_service/internal/quota/quotatest"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	quotatest.Run(t, func(t *testing.T) quota.Store {
+		s, err := quota.NewStore("sqlite://" + filepath.Join(t.TempDir(), "quota.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
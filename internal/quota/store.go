@@ -2,14 +2,97 @@ package quota
 
 import (
 	"context"
+	"fmt"
+	"net/url"
 	"time"
+
+	"github.com/ilivestrong/email_warmup_service/internal/inbox"
 )
 
+// Store keeps daily quotas and scores per Scope. Every date is a tenant-local
+// calendar day formatted with clock.DateLayout.
 type Store interface {
-	DeductQuota(ctx context.Context, tenantID string, date string) (bool, int, error)
-	ResetQuota(ctx context.Context, tenantID string, date string, count int) error
-	SaveScore(ctx context.Context, tenantID, date string, score int) error
-	GetScores(ctx context.Context, tenantID, date string) ([]int, error)
-	IncreaseQuota(ctx context.Context, tenantID, date string) error
-	GetRemainingQuota(ctx context.Context, tenantID string, date time.Time) (int, error)
+	// DeductQuota spends one unit of the scope's quota and of all its rollups,
+	// returning the remaining quota of the scope itself. It also marks the
+	// tenant as active.
+	DeductQuota(ctx context.Context, s Scope, date string) (bool, int, error)
+	ResetQuota(ctx context.Context, s Scope, date string, count int) error
+	// InitQuota starts the day with the scope's allotment, or base while it
+	// has none, unless the day already has a quota.
+	InitQuota(ctx context.Context, s Scope, date string, base int) (bool, error)
+	// SaveScore records the score for the scope and all its rollups.
+	SaveScore(ctx context.Context, s Scope, date string, rec ScoreRecord) error
+	GetScores(ctx context.Context, s Scope, date string) ([]ScoreRecord, error)
+	// IncreaseQuota scales the scope's allotment by factor, starting from
+	// base while it has none, normally the scope's InitialQuota. The day
+	// gains the difference if it has started, or starts with the new
+	// allotment; later days start with it too. It returns ErrFenced without
+	// writing if f does not admit the write.
+	IncreaseQuota(ctx context.Context, s Scope, date string, base int, factor float64, f Fence) error
+	GetRemainingQuota(ctx context.Context, s Scope, date string) (int, error)
+
+	// SetProviderLimit throttles what the scope may send to one mailbox
+	// provider that day. Providers without a limit are only bound by quota.
+	SetProviderLimit(ctx context.Context, s Scope, p inbox.Provider, date string, limit int) error
+	// ProviderLimit reports the remaining provider limit and whether one is set.
+	ProviderLimit(ctx context.Context, s Scope, p inbox.Provider, date string) (int, bool, error)
+	// DeductProviderLimit spends one unit of every provider limit set on the
+	// scope and its rollups.
+	DeductProviderLimit(ctx context.Context, s Scope, p inbox.Provider, date string) error
+
+	// SaveReputation appends a point to the scope's reputation history,
+	// replacing the latest point if it is for the same date.
+	SaveReputation(ctx context.Context, s Scope, rep Reputation) error
+	// ReputationHistory returns up to limit of the latest points, oldest
+	// first. A limit of zero or less returns the whole history.
+	ReputationHistory(ctx context.Context, s Scope, limit int) ([]Reputation, error)
+
+	// Tenants lists every tenant that has spent quota.
+	Tenants(ctx context.Context) ([]string, error)
+	SaveDailyReport(ctx context.Context, rep DailyReport) error
+	// GetDailyReport reports false if no report exists for that day.
+	GetDailyReport(ctx context.Context, tenantID, date string) (DailyReport, bool, error)
+
+	// LastRun reports when a scheduled job last completed, and false if it
+	// never has.
+	LastRun(ctx context.Context, job string) (time.Time, bool, error)
+	// SetLastRun returns ErrFenced without writing if f does not admit the
+	// write.
+	SetLastRun(ctx context.Context, job string, at time.Time, f Fence) error
+}
+
+// NewStore opens the store named by the URL scheme: redis:// or rediss:// for
+// Redis, memory:// for an in-process store, and sqlite://path/to/file.db for
+// an embedded SQLite database.
+func NewStore(storeURL string) (Store, error) {
+	u, err := url.Parse(storeURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "redis", "rediss":
+		return newRedisStore(storeURL)
+	case "memory":
+		return NewMemoryStore(), nil
+	case "sqlite":
+		return newSQLiteStore(u.Host + u.Path)
+	}
+	return nil, fmt.Errorf("unsupported quota store URL scheme %q", u.Scheme)
 }
+
+// Keys shared by the key-value style stores.
+
+func quotaKey(s Scope, date string) string { return fmt.Sprintf("quota:%s:%s", s, date) }
+
+func limitKey(s Scope, p inbox.Provider, date string) string {
+	return fmt.Sprintf("limit:%s:%s:%s", s, p, date)
+}
+
+func scoreKey(s Scope, date string) string { return fmt.Sprintf("score:%s:%s", s, date) }
+
+// allotmentKey holds the quota each day of the scope starts with. It has no
+// date and does not expire, so increases build up from day to day.
+func allotmentKey(s Scope) string { return fmt.Sprintf("allotment:%s", s) }
+
+// scale applies an increase factor to an allotment.
+func scale(allotment int, factor float64) int { return int(float64(allotment) * factor) }
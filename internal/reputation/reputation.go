@@ -0,0 +1,74 @@
+package reputation
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/ilivestrong/email_warmup_service/internal/clock"
+	"github.com/ilivestrong/email_warmup_service/internal/quota"
+	"github.com/ilivestrong/email_warmup_service/internal/scoring"
+)
+
+// Engine maintains an exponentially weighted moving reputation per scope, so
+// a single good day cannot outweigh a bad week. Older days lose half their
+// weight every half-life.
+type Engine struct {
+	store      quota.Store
+	halfLife   float64 // days
+	minSamples int
+}
+
+func New(store quota.Store, halfLifeDays float64, minSamples int) *Engine {
+	if halfLifeDays <= 0 {
+		halfLifeDays = 1
+	}
+	return &Engine{store: store, halfLife: halfLifeDays, minSamples: minSamples}
+}
+
+// Update folds the breakdown of date into the scope's reputation and persists
+// the new point. Re-running a date replaces its point instead of counting the
+// day twice.
+func (e *Engine) Update(ctx context.Context, s quota.Scope, date string, b scoring.Breakdown) (quota.Reputation, error) {
+	history, err := e.store.ReputationHistory(ctx, s, 2)
+	if err != nil {
+		return quota.Reputation{}, err
+	}
+	if n := len(history); n > 0 && history[n-1].Date == date {
+		history = history[:n-1]
+	}
+
+	rep := quota.Reputation{Date: date, DayAverage: b.Average, DaySamples: b.Samples}
+	if n := len(history); n > 0 {
+		prev := history[n-1]
+		decay := math.Pow(0.5, daysBetween(prev.Date, date)/e.halfLife)
+		rep.Weight = prev.Weight * decay
+		rep.Score = prev.Score
+		if rep.Weight+float64(b.Samples) > 0 {
+			rep.Score = (prev.Score*rep.Weight + b.Average*float64(b.Samples)) / (rep.Weight + float64(b.Samples))
+		}
+		rep.Weight += float64(b.Samples)
+	} else {
+		rep.Score = b.Average
+		rep.Weight = float64(b.Samples)
+	}
+
+	if err := e.store.SaveReputation(ctx, s, rep); err != nil {
+		return quota.Reputation{}, err
+	}
+	return rep, nil
+}
+
+// Ready reports whether enough sends back the reputation to act on it.
+func (e *Engine) Ready(rep quota.Reputation) bool {
+	return rep.Weight >= float64(e.minSamples)
+}
+
+func daysBetween(from, to string) float64 {
+	f, err1 := time.Parse(clock.DateLayout, from)
+	t, err2 := time.Parse(clock.DateLayout, to)
+	if err1 != nil || err2 != nil || !t.After(f) {
+		return 0
+	}
+	return t.Sub(f).Hours() / 24
+}
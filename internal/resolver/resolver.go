@@ -3,20 +3,34 @@ package resolver
 import (
 	"context"
 	"fmt"
+	"strings"
 )
 
+// Resolver lists the sender mailboxes a tenant warms up.
 type Resolver interface {
-	Resolve(ctx context.Context, tenantID string) (string, error)
+	Senders(ctx context.Context, tenantID string) ([]string, error)
 }
 
-type StaticMapResolver struct{ m map[string]string }
+type StaticMapResolver struct{ m map[string][]string }
 
-func NewStatic(m map[string]string) *StaticMapResolver { return &StaticMapResolver{m: m} }
+// NewStatic builds a resolver from a tenant to sender map whose values are
+// comma-separated address lists.
+func NewStatic(m map[string]string) *StaticMapResolver {
+	senders := make(map[string][]string, len(m))
+	for tid, list := range m {
+		for _, addr := range strings.Split(list, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				senders[tid] = append(senders[tid], addr)
+			}
+		}
+	}
+	return &StaticMapResolver{m: senders}
+}
 
-func (s *StaticMapResolver) Resolve(_ context.Context, tid string) (string, error) {
-	addr, ok := s.m[tid]
+func (s *StaticMapResolver) Senders(_ context.Context, tid string) ([]string, error) {
+	addrs, ok := s.m[tid]
 	if !ok {
-		return "", fmt.Errorf("no sender address for tenant %s", tid)
+		return nil, fmt.Errorf("no sender address for tenant %s", tid)
 	}
-	return addr, nil
+	return addrs, nil
 }
@@ -0,0 +1,140 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed five-field cron expression: minute, hour, day of
+// month, month and day of week. Fields accept *, lists, ranges and steps,
+// e.g. "*/15 0-6,22 * * 1-5". The macros @hourly, @daily, @weekly and
+// @monthly are also understood.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+var macros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+}
+
+func ParseCron(spec string) (*Schedule, error) {
+	if m, ok := macros[strings.TrimSpace(spec)]; ok {
+		spec = m
+	}
+	f := strings.Fields(spec)
+	if len(f) != 5 {
+		return nil, fmt.Errorf("cron %q: want 5 fields, got %d", spec, len(f))
+	}
+	s := &Schedule{domStar: f[2] == "*", dowStar: f[4] == "*"}
+	var err error
+	fields := []struct {
+		dst      *uint64
+		min, max int
+	}{{&s.minute, 0, 59}, {&s.hour, 0, 23}, {&s.dom, 1, 31}, {&s.month, 1, 12}, {&s.dow, 0, 7}}
+	for i, fd := range fields {
+		if *fd.dst, err = parseField(f[i], fd.min, fd.max); err != nil {
+			return nil, fmt.Errorf("cron %q: %w", spec, err)
+		}
+	}
+	if s.dow&(1<<7) != 0 { // 7 is Sunday too
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+func parseField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+			rng, step = part[:i], n
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			var err error
+			bounds := strings.SplitN(rng, "-", 2)
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("bad value in %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("bad value in %q", part)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func has(bits uint64, v int) bool { return bits&(1<<v) != 0 }
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom, dow := has(s.dom, t.Day()), has(s.dow, int(t.Weekday()))
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first time strictly after after that matches the
+// schedule, evaluated in after's location. It returns the zero time if there
+// is none within five years. The search runs over wall clock readings, so a
+// time that a DST transition skips fires at the matching instant after the
+// jump, and one that repeats fires only the first time.
+func (s *Schedule) Next(after time.Time) time.Time {
+	loc := after.Location()
+	t := wall(after).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		y, m, d := t.Date()
+		switch {
+		case !has(s.month, int(m)):
+			t = time.Date(y, m+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.dayMatches(t):
+			t = time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+		case !has(s.hour, t.Hour()):
+			t = time.Date(y, m, d, t.Hour()+1, 0, 0, 0, time.UTC)
+		case !has(s.minute, t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			at := time.Date(y, m, d, t.Hour(), t.Minute(), 0, 0, loc)
+			if !wall(at).Equal(t) {
+				// t falls in a DST gap; read it with the offset before the jump.
+				_, off := at.Add(-24 * time.Hour).Zone()
+				at = t.Add(-time.Duration(off) * time.Second).In(loc)
+			}
+			if at.After(after) {
+				return at
+			}
+			t = t.Add(time.Minute)
+		}
+	}
+	return time.Time{}
+}
+
+// wall returns t's wall clock reading as a UTC time, which has no DST.
+func wall(t time.Time) time.Time {
+	y, m, d := t.Date()
+	h, min, sec := t.Clock()
+	return time.Date(y, m, d, h, min, sec, t.Nanosecond(), time.UTC)
+}
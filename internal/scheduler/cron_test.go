@@ -0,0 +1,156 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ilivestrong/email_warmup_service/internal/clock"
+)
+
+func bits(vs ...int) uint64 {
+	var b uint64
+	for _, v := range vs {
+		b |= 1 << v
+	}
+	return b
+}
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		spec                          string
+		minute, hour, dom, month, dow uint64
+	}{
+		{"*/15 0-6,22 * * 1-5", bits(0, 15, 30, 45), bits(0, 1, 2, 3, 4, 5, 6, 22), bits(rangeOf(1, 31)...), bits(rangeOf(1, 12)...), bits(1, 2, 3, 4, 5)},
+		{"5 4 1,15 */3 *", bits(5), bits(4), bits(1, 15), bits(1, 4, 7, 10), bits(rangeOf(0, 7)...)},
+		{"0 12 * * 7", bits(0), bits(12), bits(rangeOf(1, 31)...), bits(rangeOf(1, 12)...), bits(0, 7)},
+		{"10/20 * * * *", bits(10, 30, 50), bits(rangeOf(0, 23)...), bits(rangeOf(1, 31)...), bits(rangeOf(1, 12)...), bits(rangeOf(0, 7)...)},
+	}
+	for _, tc := range tests {
+		s, err := ParseCron(tc.spec)
+		if err != nil {
+			t.Fatalf("ParseCron(%q): %v", tc.spec, err)
+		}
+		if s.minute != tc.minute || s.hour != tc.hour || s.dom != tc.dom || s.month != tc.month || s.dow != tc.dow {
+			t.Errorf("ParseCron(%q) = %+v", tc.spec, s)
+		}
+	}
+}
+
+func rangeOf(lo, hi int) []int {
+	var out []int
+	for v := lo; v <= hi; v++ {
+		out = append(out, v)
+	}
+	return out
+}
+
+func TestParseCronMacros(t *testing.T) {
+	for macro, spec := range macros {
+		got, err := ParseCron(" " + macro + " ")
+		if err != nil {
+			t.Fatalf("ParseCron(%q): %v", macro, err)
+		}
+		want, _ := ParseCron(spec)
+		if *got != *want {
+			t.Errorf("%s = %+v, want %+v", macro, got, want)
+		}
+	}
+}
+
+func TestParseCronRejects(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"@yearly",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"1-b * * * *",
+		"1,,2 * * * *",
+	} {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("ParseCron(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no tz database:", err)
+	}
+	utc := func(y int, m time.Month, d, h, min int) time.Time { return time.Date(y, m, d, h, min, 0, 0, time.UTC) }
+	tests := []struct {
+		name  string
+		spec  string
+		after time.Time
+		want  time.Time
+	}{
+		{"next minute", "* * * * *", utc(2025, 1, 2, 3, 4).Add(30 * time.Second), utc(2025, 1, 2, 3, 5)},
+		{"strictly after", "0 0 * * *", utc(2025, 1, 2, 0, 0), utc(2025, 1, 3, 0, 0)},
+		{"month rollover", "0 9 1 * *", utc(2025, 1, 31, 10, 0), utc(2025, 2, 1, 9, 0)},
+		{"skips short months", "0 0 31 * *", utc(2025, 4, 1, 0, 0), utc(2025, 5, 31, 0, 0)},
+		{"leap day", "0 0 29 2 *", utc(2025, 1, 1, 0, 0), utc(2028, 2, 29, 0, 0)},
+		{"day of month or week", "0 0 13 * 1", utc(2025, 6, 3, 0, 0), utc(2025, 6, 9, 0, 0)},
+		{"day of month and any week day", "0 0 13 * *", utc(2025, 6, 1, 0, 0), utc(2025, 6, 13, 0, 0)},
+		{"sunday as 7", "0 0 * * 7", utc(2025, 6, 2, 0, 0), utc(2025, 6, 8, 0, 0)},
+		{"never", "0 0 30 2 *", utc(2025, 1, 1, 0, 0), time.Time{}},
+		{"local zone", "0 0 * * *", time.Date(2025, 1, 2, 12, 0, 0, 0, ny), time.Date(2025, 1, 3, 0, 0, 0, 0, ny)},
+		// 02:30 does not exist on 9 March 2025 in New York; the run fires
+		// at the same instant the clock jumps past, 03:30 EDT.
+		{"dst gap", "30 2 * * *", time.Date(2025, 3, 8, 3, 0, 0, 0, ny), utc(2025, 3, 9, 7, 30)},
+		{"after dst gap", "30 2 * * *", utc(2025, 3, 9, 7, 30).In(ny), time.Date(2025, 3, 10, 2, 30, 0, 0, ny)},
+		// 01:30 happens twice on 2 November 2025 in New York; only the
+		// first, EDT, occurrence fires.
+		{"dst overlap", "30 1 * * *", time.Date(2025, 11, 2, 0, 0, 0, 0, ny), utc(2025, 11, 2, 5, 30)},
+		{"after dst overlap", "30 1 * * *", utc(2025, 11, 2, 5, 30).In(ny), time.Date(2025, 11, 3, 1, 30, 0, 0, ny)},
+		{"hourly through overlap", "0 * * * *", utc(2025, 11, 2, 5, 0).In(ny), utc(2025, 11, 2, 7, 0)},
+	}
+	for _, tc := range tests {
+		s, err := ParseCron(tc.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := s.Next(tc.after); !got.Equal(tc.want) {
+			t.Errorf("%s: Next(%s) = %s, want %s", tc.name, tc.after, got, tc.want)
+		}
+	}
+}
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time { return time.Time(c) }
+
+func TestFirstDue(t *testing.T) {
+	now := time.Date(2025, 2, 15, 12, 0, 0, 0, time.UTC)
+	tc, err := clock.NewTenants(fixedClock(now), nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Scheduler{tc: tc}
+	sched, _ := ParseCron("@daily")
+	day := func(m time.Month, d int) time.Time { return time.Date(2025, m, d, 0, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name       string
+		last, want time.Time
+	}{
+		{"up to date", day(2, 15), day(2, 16)},
+		{"one missed", day(2, 14), day(2, 15)},
+		{"few missed", day(2, 10), day(2, 11)},
+		{"exactly the cap", day(1, 15), day(1, 16)},
+		{"beyond the cap", day(1, 1), day(1, 16)},
+	}
+	for _, tt := range tests {
+		e := &entry{job: Job{Name: "job", Location: time.UTC}, sched: sched, last: tt.last}
+		if got := s.firstDue(e); !got.Equal(tt.want) {
+			t.Errorf("%s: firstDue = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ilivestrong/email_warmup_service/internal/quota"
+)
+
+// Job is a unit of scheduled work. Run receives the occurrence it executes,
+// which lies in the past when a missed run is being caught up.
+type Job struct {
+	Name     string
+	Spec     string         // cron expression, see ParseCron
+	Location *time.Location // zone the expression is evaluated in; UTC if nil
+	Run      func(ctx context.Context, at time.Time) error
+}
+
+type entry struct {
+	job   Job
+	sched *Schedule
+	last  time.Time // last completed occurrence
+	next  time.Time // next occurrence to run
+	retry time.Time // earliest retry of next after a failure
+}
+
+// wake is when the entry should next be attempted.
+func (e *entry) wake() time.Time {
+	if e.retry.After(e.next) {
+		return e.retry
+	}
+	return e.next
+}
+
+// Leadership gates scheduled work so that only one replica runs it.
+// *leader.Elector implements it.
+type Leadership interface {
+	IsLeader() bool
+	// Term numbers the current leadership, and is 0 while not leading.
+	Term() int64
+	// Confirm returns an error unless this instance still leads.
+	Confirm(ctx context.Context) error
+}
+
+// fenceName is what the scheduler's fenced writes are guarded under.
+const fenceName = "scheduler"
+
+// fence guards a write that only the current leader may make.
+func (s *Scheduler) fence() quota.Fence {
+	return quota.Fence{Name: fenceName, Term: s.lead.Term()}
+}
+
+const (
+	// followerPoll is how often a replica that does not lead checks again.
+	followerPoll = 5 * time.Second
+	// retryDelay is how long a failed occurrence waits before it is retried.
+	retryDelay = time.Minute
+	// maxCatchUp bounds how many missed occurrences of a job are replayed;
+	// older ones are skipped.
+	maxCatchUp = 31
+)
+
+// Register adds a job. Its completed runs are persisted, so occurrences
+// missed while the service was down are caught up in order. A job that has
+// never run starts from the time it is first registered.
+func (s *Scheduler) Register(ctx context.Context, job Job) error {
+	sched, err := ParseCron(job.Spec)
+	if err != nil {
+		return err
+	}
+	if job.Location == nil {
+		job.Location = time.UTC
+	}
+
+	last, ok, err := s.store.LastRun(ctx, job.Name)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", job.Name, err)
+	}
+	if !ok {
+		// Any replica may start the job's history; it is not fenced.
+		last = s.tc.Now("")
+		if err := s.store.SetLastRun(ctx, job.Name, last, quota.Fence{}); err != nil {
+			return fmt.Errorf("job %s: %w", job.Name, err)
+		}
+	}
+
+	e := &entry{job: job, sched: sched, last: last}
+	e.next = s.firstDue(e)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, dup := s.jobs[job.Name]; dup {
+		return fmt.Errorf("job %s already registered", job.Name)
+	}
+	s.jobs[job.Name] = e
+	return nil
+}
+
+func (s *Scheduler) registered(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.jobs[name]
+	return ok
+}
+
+// firstDue skips occurrences beyond the last maxCatchUp missed ones.
+func (s *Scheduler) firstDue(e *entry) time.Time {
+	now := s.tc.Now("")
+	var missed []time.Time
+	for at := e.sched.Next(e.last.In(e.job.Location)); !at.IsZero() && !at.After(now); at = e.sched.Next(at) {
+		missed = append(missed, at)
+		if len(missed) > maxCatchUp {
+			missed = missed[1:]
+		}
+	}
+	if len(missed) == 0 {
+		return e.sched.Next(now.In(e.job.Location))
+	}
+	if skipped := e.sched.Next(e.last.In(e.job.Location)); !skipped.Equal(missed[0]) {
+		log.Printf("job %s: skipping missed runs before %s", e.job.Name, missed[0])
+	}
+	return missed[0]
+}
+
+// Start runs registered jobs until ctx is done. Due occurrences run one at a
+// time, oldest first, and only while this instance holds leadership.
+func (s *Scheduler) Start(ctx context.Context) {
+	var synced time.Time
+	for {
+		now := s.tc.Now("")
+		if synced.IsZero() || !now.Before(synced.Add(tenantRefresh)) {
+			s.syncTenants(ctx)
+			synced = now
+		}
+
+		leading := s.lead.IsLeader()
+		if e := s.due(now); e != nil && leading {
+			s.runOnce(ctx, e)
+			continue
+		}
+
+		wake := synced.Add(tenantRefresh)
+		if !leading && now.Add(followerPoll).Before(wake) {
+			wake = now.Add(followerPoll)
+		}
+		s.mu.Lock()
+		for _, e := range s.jobs {
+			if w := e.wake(); !e.next.IsZero() && w.Before(wake) {
+				wake = w
+			}
+		}
+		s.mu.Unlock()
+
+		timer := time.NewTimer(wake.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			log.Println("scheduler stopped")
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// due returns the job whose next occurrence is the oldest one that can be
+// attempted at now.
+func (s *Scheduler) due(now time.Time) *entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out *entry
+	for _, e := range s.jobs {
+		if e.next.IsZero() || e.wake().After(now) {
+			continue
+		}
+		if out == nil || e.next.Before(out.next) {
+			out = e
+		}
+	}
+	return out
+}
+
+func (s *Scheduler) runOnce(ctx context.Context, e *entry) {
+	at := e.next
+	if err := s.lead.Confirm(ctx); err != nil {
+		log.Printf("job %s: not running occurrence %s: %v", e.job.Name, at, err)
+		s.retryLater(e)
+		return
+	}
+
+	// Another leader may have run this occurrence since it was computed.
+	last, ok, err := s.store.LastRun(ctx, e.job.Name)
+	if err != nil {
+		log.Printf("job %s: failed to read last run: %v", e.job.Name, err)
+		s.retryLater(e)
+		return
+	}
+	if ok && !last.Before(at) {
+		s.mu.Lock()
+		e.last = last
+		e.next = e.sched.Next(last.In(e.job.Location))
+		s.mu.Unlock()
+		return
+	}
+
+	log.Printf("job %s: running occurrence %s as leader", e.job.Name, at)
+	if err := e.job.Run(ctx, at); err != nil {
+		log.Printf("job %s: occurrence %s failed, retrying in %s: %v", e.job.Name, at, retryDelay, err)
+		s.retryLater(e)
+		return
+	}
+	if err := s.lead.Confirm(ctx); err != nil {
+		// The occurrence is not recorded as completed, so whoever leads
+		// next redoes it; here it waits out the retry delay first.
+		log.Printf("job %s: lost leadership during occurrence %s: %v", e.job.Name, at, err)
+		s.retryLater(e)
+		return
+	}
+	if err := s.store.SetLastRun(ctx, e.job.Name, at, s.fence()); errors.Is(err, quota.ErrFenced) {
+		log.Printf("job %s: a newer leader took over during occurrence %s", e.job.Name, at)
+		s.retryLater(e)
+		return
+	} else if err != nil {
+		log.Printf("job %s: failed to persist last run: %v", e.job.Name, err)
+	}
+	s.mu.Lock()
+	e.last = at
+	e.next = e.sched.Next(at)
+	e.retry = time.Time{}
+	s.mu.Unlock()
+}
+
+// retryLater holds off the entry's next attempt for retryDelay.
+func (s *Scheduler) retryLater(e *entry) {
+	s.mu.Lock()
+	e.retry = s.tc.Now("").Add(retryDelay)
+	s.mu.Unlock()
+}
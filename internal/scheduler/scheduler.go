@@ -2,59 +2,205 @@ package scheduler
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"sync"
 	"time"
 
+	"github.com/ilivestrong/email_warmup_service/internal/clock"
 	"github.com/ilivestrong/email_warmup_service/internal/config"
+	"github.com/ilivestrong/email_warmup_service/internal/inbox"
 	"github.com/ilivestrong/email_warmup_service/internal/providers"
+	"github.com/ilivestrong/email_warmup_service/internal/queue"
+	"github.com/ilivestrong/email_warmup_service/internal/queue/events"
 	"github.com/ilivestrong/email_warmup_service/internal/quota"
+	"github.com/ilivestrong/email_warmup_service/internal/reputation"
+	"github.com/ilivestrong/email_warmup_service/internal/resolver"
+	"github.com/ilivestrong/email_warmup_service/internal/scoring"
 )
 
 type Scheduler struct {
 	cfg     *config.Config
 	store   quota.Store
 	factory *providers.Factory
+	senders resolver.Resolver
+	tc      *clock.Tenants
+	model   *scoring.Model
+	rep     *reputation.Engine
+	events  queue.Client
+	lead    Leadership
+
+	mu   sync.Mutex
+	jobs map[string]*entry
 }
 
-func NewScheduler(cfg *config.Config, store quota.Store, factory *providers.Factory) *Scheduler {
-	return &Scheduler{cfg: cfg, store: store, factory: factory}
+func NewScheduler(cfg *config.Config, store quota.Store, factory *providers.Factory, senders resolver.Resolver, tc *clock.Tenants, model *scoring.Model, rep *reputation.Engine, events queue.Client, lead Leadership) *Scheduler {
+	return &Scheduler{cfg: cfg, store: store, factory: factory, senders: senders, tc: tc, model: model, rep: rep, events: events, lead: lead, jobs: map[string]*entry{}}
 }
 
-func (s *Scheduler) StartDaily(ctx context.Context) {
-	ticker := time.NewTicker(24 * time.Hour)
-	defer ticker.Stop()
+// tenantRefresh bounds how long a newly active tenant waits to get its
+// daily job.
+const tenantRefresh = time.Hour
+
+// dailyJobPrefix names the per-tenant daily score jobs, e.g. "daily-score:t1".
+const dailyJobPrefix = "daily-score:"
 
-	for {
-		select {
-		case <-ctx.Done():
-			log.Println("daily scheduler stopped")
-			return
-		case <-ticker.C:
-			s.runDailyScoreCheck(ctx)
+// syncTenants registers a daily score job, evaluated in the tenant's
+// timezone, for every active tenant that does not have one yet.
+func (s *Scheduler) syncTenants(ctx context.Context) {
+	tenants, err := s.store.Tenants(ctx)
+	if err != nil {
+		log.Printf("failed to list active tenants: %v", err)
+		return
+	}
+	for _, tenantID := range tenants {
+		name := dailyJobPrefix + tenantID
+		if s.registered(name) {
+			continue
+		}
+		err := s.Register(ctx, Job{
+			Name:     name,
+			Spec:     s.cfg.DailyScoreCron,
+			Location: s.tc.Location(tenantID),
+			Run: func(ctx context.Context, at time.Time) error {
+				return s.runDailyScoreCheck(ctx, tenantID, at)
+			},
+		})
+		if err != nil {
+			log.Printf("failed to schedule daily score check for %s: %v", tenantID, err)
 		}
 	}
 }
 
-func (s *Scheduler) runDailyScoreCheck(ctx context.Context) {
-	for tenantID := range s.cfg.ProviderMap {
-		date := time.Now().Add(-24 * time.Hour).Format("2006-01-02")
-		scores, err := s.store.GetScores(ctx, tenantID, date)
-		if err != nil {
-			log.Printf("no scores for tenant %s: %v", tenantID, err)
-			continue
+// runDailyScoreCheck folds the local day that ended before at into the
+// rolling reputation and scales the quota of the local day at falls in,
+// independently for the tenant, each of its sending domains and each sender
+// mailbox. The resulting DailyReport is persisted and emitted. A caught up
+// occurrence whose day has already passed only updates the reputation, so
+// an outage does not stack several increases onto the current day.
+func (s *Scheduler) runDailyScoreCheck(ctx context.Context, tenantID string, at time.Time) error {
+	yesterday := s.tc.Date(tenantID, at, -1)
+	today := s.tc.Date(tenantID, at, 0)
+	past := today != s.tc.Today(tenantID)
+
+	report := quota.DailyReport{TenantID: tenantID, Date: yesterday}
+	scopes, initial := s.scopes(ctx, tenantID)
+	for _, scope := range scopes {
+		report.Scopes = append(report.Scopes, s.checkScope(ctx, scope, initial(scope), yesterday, today, past))
+	}
+
+	if err := s.store.SaveDailyReport(ctx, report); err != nil {
+		return fmt.Errorf("saving daily report for %s: %w", tenantID, err)
+	}
+	if err := s.events.Emit(ctx, events.KindDailyReport, report); err != nil {
+		log.Printf("failed to emit daily report for %s: %v", tenantID, err)
+	}
+	return nil
+}
+
+// checkScope decides the scope's quota for today, increasing its allotment
+// from base when it has none yet. Quota and provider limits are left alone
+// when today has already passed.
+func (s *Scheduler) checkScope(ctx context.Context, scope quota.Scope, base int, yesterday, today string, past bool) quota.ScopeReport {
+	sr := quota.ScopeReport{Scope: scope.String()}
+
+	scores, err := s.store.GetScores(ctx, scope, yesterday)
+	if err != nil {
+		sr.Decision, sr.Reason = quota.DecisionFailed, fmt.Sprintf("reading scores: %v", err)
+		return sr
+	}
+
+	b := s.model.Breakdown(scores)
+	sr.Sends, sr.Outcomes, sr.Average = b.Samples, b.Counts, b.Average
+	log.Printf("score breakdown for %s on %s: avg %.2f over %d sends, counts %v, contributions %v",
+		scope, yesterday, b.Average, b.Samples, b.Counts, b.Contributions)
+
+	rep, err := s.rep.Update(ctx, scope, yesterday, b)
+	if err != nil {
+		sr.Decision, sr.Reason = quota.DecisionFailed, fmt.Sprintf("updating reputation: %v", err)
+		return sr
+	}
+	sr.Reputation, sr.ReputationWeight = rep.Score, rep.Weight
+	if !past {
+		sr.Throttled = s.throttleProviders(ctx, scope, scores, today)
+	}
+
+	switch {
+	case b.Samples == 0:
+		sr.Decision, sr.Reason = quota.DecisionInsufficientData, "no sends on "+yesterday
+	case !s.rep.Ready(rep):
+		sr.Decision = quota.DecisionInsufficientData
+		sr.Reason = fmt.Sprintf("reputation backed by %.1f sends, need %d", rep.Weight, s.cfg.ReputationMinSamples)
+	case rep.Score < s.cfg.QuotaScoreThreshold:
+		sr.Decision = quota.DecisionHold
+		sr.Reason = fmt.Sprintf("reputation %.2f below threshold %.2f", rep.Score, s.cfg.QuotaScoreThreshold)
+	case past:
+		sr.Decision = quota.DecisionHold
+		sr.Reason = fmt.Sprintf("caught up after %s ended, only the current day's quota is scaled", today)
+	default:
+		if err := s.store.IncreaseQuota(ctx, scope, today, base, s.cfg.QuotaScaleFactor, s.fence()); err != nil {
+			sr.Decision, sr.Reason = quota.DecisionFailed, fmt.Sprintf("increasing quota: %v", err)
+			break
 		}
+		sr.Decision = quota.DecisionIncrease
+		sr.Reason = fmt.Sprintf("reputation %.2f at or above threshold %.2f", rep.Score, s.cfg.QuotaScoreThreshold)
+	}
+	log.Printf("daily decision for %s: %s (%s)", scope, sr.Decision, sr.Reason)
+	return sr
+}
+
+const (
+	// throttleMinSends is how many sends to a provider are needed before its
+	// rates are trusted.
+	throttleMinSends    = 10
+	throttleSpamRate    = 0.1
+	throttleMinDelivery = 0.8
+)
 
-		total := 0
-		for _, score := range scores {
-			total += score
+// throttleProviders limits today's volume towards each mailbox provider that
+// put too much of yesterday's mail in spam or failed to deliver it, to half of
+// what was sent there. Other providers are left alone. It returns the limits
+// it set.
+func (s *Scheduler) throttleProviders(ctx context.Context, scope quota.Scope, scores []quota.ScoreRecord, today string) map[inbox.Provider]int {
+	var set map[inbox.Provider]int
+	for prov, st := range quota.ByProvider(scores) {
+		if st.Sent < throttleMinSends {
+			continue
 		}
-		avg := float64(total) / float64(len(scores))
+		if st.SpamRate() <= throttleSpamRate && st.DeliveryRate() >= throttleMinDelivery {
+			continue
+		}
+		limit := max(st.Sent/2, 1)
+		log.Printf("throttling %s towards %s to %d (spam %.2f, delivery %.2f)", scope, prov, limit, st.SpamRate(), st.DeliveryRate())
+		if err := s.store.SetProviderLimit(ctx, scope, prov, today, limit); err != nil {
+			log.Printf("failed to throttle provider: %v", err)
+			continue
+		}
+		if set == nil {
+			set = map[inbox.Provider]int{}
+		}
+		set[prov] = limit
+	}
+	return set
+}
 
-		if avg >= 0.8 {
-			log.Printf("scaling quota for %s due to good score %.2f", tenantID, avg)
-			if err := s.store.IncreaseQuota(ctx, tenantID, date); err != nil {
-				log.Printf("failed to increase quota: %v", err)
-			}
+// scopes lists the tenant followed by its sending domains and sender
+// mailboxes, and returns the day-start quota of each.
+func (s *Scheduler) scopes(ctx context.Context, tenantID string) ([]quota.Scope, func(quota.Scope) int) {
+	out := []quota.Scope{quota.TenantScope(tenantID)}
+	addrs, err := s.senders.Senders(ctx, tenantID)
+	if err != nil {
+		log.Printf("no senders for tenant %s: %v", tenantID, err)
+		return out, quota.InitialQuota(nil)
+	}
+	seen := map[string]bool{}
+	for _, addr := range addrs {
+		sender := quota.SenderScope(tenantID, addr)
+		if !seen[sender.Domain] {
+			seen[sender.Domain] = true
+			out = append(out, quota.DomainScope(tenantID, sender.Domain))
 		}
+		out = append(out, sender)
 	}
+	return out, quota.InitialQuota(addrs)
 }
@@ -0,0 +1,95 @@
+package scoring
+
+import (
+	"fmt"
+	"maps"
+
+	"github.com/ilivestrong/email_warmup_service/internal/quota"
+)
+
+// DefaultWeights is what each outcome adds to a send's score unless
+// configured otherwise.
+var DefaultWeights = map[quota.Outcome]float64{
+	quota.Delivered:  2,
+	quota.SoftBounce: -1,
+	quota.HardBounce: -2,
+	quota.Opened:     1,
+	quota.Replied:    2,
+	quota.Spam:       -2,
+	quota.Rescued:    1,
+}
+
+// Model scores sends as the weighted sum of their outcomes.
+type Model struct {
+	weights map[quota.Outcome]float64
+}
+
+// New builds a model from DefaultWeights overridden by weights, which is
+// keyed by outcome name. Unknown outcomes are rejected.
+func New(weights map[string]float64) (*Model, error) {
+	w := maps.Clone(DefaultWeights)
+	for name, weight := range weights {
+		o := quota.Outcome(name)
+		if _, ok := DefaultWeights[o]; !ok {
+			return nil, fmt.Errorf("unknown score outcome %q", name)
+		}
+		w[o] = weight
+	}
+	return &Model{weights: w}, nil
+}
+
+func (m *Model) Weight(o quota.Outcome) float64 { return m.weights[o] }
+
+func (m *Model) Score(outcomes []quota.Outcome) float64 {
+	score := 0.0
+	for _, o := range outcomes {
+		score += m.weights[o]
+	}
+	return score
+}
+
+// Breakdown explains an average score: how many sends had each outcome and
+// how much that outcome contributed to the average.
+type Breakdown struct {
+	Samples       int
+	Average       float64
+	Counts        map[quota.Outcome]int
+	Contributions map[quota.Outcome]float64
+	// Unstructured counts legacy records that only carry a score.
+	Unstructured int
+}
+
+// Breakdown computes the average score of recs from their outcomes with the
+// model's current weights.
+func (m *Model) Breakdown(recs []quota.ScoreRecord) Breakdown {
+	b := Breakdown{
+		Counts:        map[quota.Outcome]int{},
+		Contributions: map[quota.Outcome]float64{},
+	}
+	for _, rec := range recs {
+		if !rec.Followup {
+			b.Samples++
+		}
+	}
+	if b.Samples == 0 {
+		return b
+	}
+	total := 0.0
+	for _, rec := range recs {
+		if len(rec.Outcomes) == 0 {
+			b.Unstructured++
+			total += rec.Score
+			continue
+		}
+		for _, o := range rec.Outcomes {
+			b.Counts[o]++
+			total += m.weights[o]
+		}
+	}
+	n := float64(b.Samples)
+	for o, c := range b.Counts {
+		b.Contributions[o] = float64(c) * m.weights[o] / n
+	}
+	b.Average = total / n
+	return b
+}
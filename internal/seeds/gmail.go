@@ -0,0 +1,99 @@
+package seeds
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ilivestrong/email_warmup_service/internal/config"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+)
+
+type gmailMailbox struct {
+	service *gmail.Service
+}
+
+func openGmail(ctx context.Context, cfg config.GoogleOAuthConfig) (*gmailMailbox, error) {
+	oauthConfig, err := google.ConfigFromJSON([]byte(cfg.GoogleCredentialsJSON), gmail.GmailModifyScope)
+	if err != nil {
+		return nil, fmt.Errorf("parsing OAuth config: %w", err)
+	}
+	token := &oauth2.Token{
+		AccessToken:  cfg.GoogleAccessToken,
+		RefreshToken: cfg.GoogleRefreshToken,
+		Expiry:       time.Now().Add(time.Hour),
+	}
+	service, err := gmail.NewService(ctx, option.WithHTTPClient(oauthConfig.Client(ctx, token)))
+	if err != nil {
+		return nil, err
+	}
+	return &gmailMailbox{service: service}, nil
+}
+
+// Rescue relabels the message: out of SPAM and into INBOX, read, important
+// and starred.
+func (g *gmailMailbox) Rescue(ctx context.Context, messageID string) (bool, error) {
+	resp, err := g.service.Users.Messages.List("me").
+		Q("in:spam rfc822msgid:" + messageID).IncludeSpamTrash(true).Context(ctx).Do()
+	if err != nil {
+		return false, err
+	}
+	for _, m := range resp.Messages {
+		_, err := g.service.Users.Messages.Modify("me", m.Id, &gmail.ModifyMessageRequest{
+			AddLabelIds:    []string{"INBOX", "IMPORTANT", "STARRED"},
+			RemoveLabelIds: []string{"SPAM", "UNREAD"},
+		}).Context(ctx).Do()
+		if err != nil {
+			return false, err
+		}
+	}
+	return len(resp.Messages) > 0, nil
+}
+
+// Label finds the message by its Message-ID and adds the label, creating the
+// label when the mailbox does not have it yet.
+func (g *gmailMailbox) Label(ctx context.Context, messageID, label string) (bool, error) {
+	resp, err := g.service.Users.Messages.List("me").
+		Q("rfc822msgid:" + messageID).IncludeSpamTrash(true).Context(ctx).Do()
+	if err != nil || len(resp.Messages) == 0 {
+		return false, err
+	}
+	labelID, err := g.labelID(ctx, label)
+	if err != nil {
+		return false, err
+	}
+	for _, m := range resp.Messages {
+		_, err := g.service.Users.Messages.Modify("me", m.Id, &gmail.ModifyMessageRequest{
+			AddLabelIds: []string{labelID},
+		}).Context(ctx).Do()
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (g *gmailMailbox) labelID(ctx context.Context, name string) (string, error) {
+	labels, err := g.service.Users.Labels.List("me").Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	for _, l := range labels.Labels {
+		if strings.EqualFold(l.Name, name) {
+			return l.Id, nil
+		}
+	}
+	created, err := g.service.Users.Labels.Create("me", &gmail.Label{
+		Name:                  name,
+		LabelListVisibility:   "labelShow",
+		MessageListVisibility: "show",
+	}).Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	return created.Id, nil
+}
@@ -0,0 +1,122 @@
+package seeds
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/microsoft"
+)
+
+const graphURL = "https://graph.microsoft.com/v1.0"
+
+// graphMailbox reaches an Outlook mailbox through Microsoft Graph.
+type graphMailbox struct {
+	client *http.Client
+}
+
+// openGraph authenticates with the seed's refresh token, which the token
+// source exchanges for access tokens as they expire.
+func openGraph(ctx context.Context, cfg OutlookConfig) *graphMailbox {
+	oauthConfig := &oauth2.Config{
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		Endpoint:     microsoft.AzureADEndpoint(cfg.Tenant),
+		Scopes:       []string{"https://graph.microsoft.com/Mail.ReadWrite", "offline_access"},
+	}
+	return &graphMailbox{client: oauthConfig.Client(ctx, &oauth2.Token{RefreshToken: cfg.RefreshToken})}
+}
+
+type graphMessage struct {
+	ID         string   `json:"id"`
+	Categories []string `json:"categories"`
+}
+
+// Rescue moves the message from Junk Email to the inbox, then marks it read,
+// high importance and flagged.
+func (g *graphMailbox) Rescue(ctx context.Context, messageID string) (bool, error) {
+	filter := "internetMessageId eq '" + strings.ReplaceAll(messageID, "'", "''") + "'"
+	var found struct {
+		Value []graphMessage `json:"value"`
+	}
+	path := "/me/mailFolders/junkemail/messages?$select=id&$filter=" + url.QueryEscape(filter)
+	if err := g.call(ctx, http.MethodGet, path, nil, &found); err != nil {
+		return false, err
+	}
+	for _, m := range found.Value {
+		var moved graphMessage
+		if err := g.call(ctx, http.MethodPost, "/me/messages/"+m.ID+"/move", map[string]string{"destinationId": "inbox"}, &moved); err != nil {
+			return false, err
+		}
+		update := map[string]any{
+			"isRead":     true,
+			"importance": "high",
+			"flag":       map[string]string{"flagStatus": "flagged"},
+		}
+		if err := g.call(ctx, http.MethodPatch, "/me/messages/"+moved.ID, update, nil); err != nil {
+			return false, err
+		}
+	}
+	return len(found.Value) > 0, nil
+}
+
+// Label finds the message by its Message-ID and adds the label to its
+// categories.
+func (g *graphMailbox) Label(ctx context.Context, messageID, label string) (bool, error) {
+	var found struct {
+		Value []graphMessage `json:"value"`
+	}
+	filter := "internetMessageId eq '" + strings.ReplaceAll(messageID, "'", "''") + "'"
+	path := "/me/messages?$select=id,categories&$filter=" + url.QueryEscape(filter)
+	if err := g.call(ctx, http.MethodGet, path, nil, &found); err != nil {
+		return false, err
+	}
+	for _, m := range found.Value {
+		if slices.Contains(m.Categories, label) {
+			continue
+		}
+		update := map[string]any{"categories": append(m.Categories, label)}
+		if err := g.call(ctx, http.MethodPatch, "/me/messages/"+m.ID, update, nil); err != nil {
+			return false, err
+		}
+	}
+	return len(found.Value) > 0, nil
+}
+
+func (g *graphMailbox) call(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, graphURL+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("graph %s %s: %s: %s", method, path, resp.Status, msg)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,267 @@
+package seeds
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// imapMailbox speaks just enough IMAP4rev1 over TLS to rescue and label a
+// message: LOGIN, LIST, SELECT, UID SEARCH, UID STORE and UID MOVE, falling
+// back to COPY and EXPUNGE on servers without MOVE.
+type imapMailbox struct {
+	cfg IMAPConfig
+}
+
+const imapTimeout = 30 * time.Second
+
+func (m *imapMailbox) Rescue(ctx context.Context, messageID string) (bool, error) {
+	folder := m.cfg.SpamFolder
+	if folder == "" {
+		folder = "Junk"
+	}
+	c, caps, err := m.login(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer c.close()
+
+	if _, err := c.cmd("SELECT %s", quote(folder)); err != nil {
+		return false, err
+	}
+	set, err := c.search("Message-ID", messageID)
+	if err != nil || set == "" {
+		return false, err
+	}
+
+	if _, err := c.cmd(`UID STORE %s +FLAGS.SILENT (\Seen \Flagged)`, set); err != nil {
+		return false, err
+	}
+	if hasCapability(caps, "MOVE") {
+		_, err = c.cmd("UID MOVE %s INBOX", set)
+		return err == nil, err
+	}
+	if _, err := c.cmd("UID COPY %s INBOX", set); err != nil {
+		return false, err
+	}
+	if _, err := c.cmd(`UID STORE %s +FLAGS.SILENT (\Deleted)`, set); err != nil {
+		return false, err
+	}
+	_, err = c.cmd("EXPUNGE")
+	return err == nil, err
+}
+
+// Label looks for the message in every folder and sets the label as a
+// keyword on it. Folders that do not keep new keywords are not labelled;
+// ErrCannotLabel is returned if the message was only found in such folders.
+func (m *imapMailbox) Label(ctx context.Context, messageID, label string) (bool, error) {
+	c, _, err := m.login(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer c.close()
+
+	listed, err := c.cmd(`LIST "" "*"`)
+	if err != nil {
+		return false, err
+	}
+	labelled, unsupported := false, false
+	for _, line := range listed {
+		folder, ok := listFolder(line)
+		if !ok {
+			continue
+		}
+		selected, err := c.cmd("SELECT %s", quote(folder))
+		if err != nil {
+			return labelled, err
+		}
+		set, err := c.search("Message-ID", messageID)
+		if err != nil {
+			return labelled, err
+		}
+		if set == "" {
+			continue
+		}
+		if !keepsKeywords(selected) {
+			unsupported = true
+			continue
+		}
+		if _, err := c.cmd("UID STORE %s +FLAGS.SILENT (%s)", set, keyword(label)); err != nil {
+			return labelled, err
+		}
+		labelled = true
+	}
+	if !labelled && unsupported {
+		return false, ErrCannotLabel
+	}
+	return labelled, nil
+}
+
+// listFolder returns the folder named by a LIST response line, and false for
+// other lines and for folders that cannot be selected.
+func listFolder(line string) (string, bool) {
+	rest, ok := strings.CutPrefix(line, "* LIST (")
+	if !ok {
+		return "", false
+	}
+	attrs, rest, ok := strings.Cut(rest, ") ")
+	if !ok {
+		return "", false
+	}
+	for _, a := range strings.Fields(attrs) {
+		if strings.EqualFold(a, `\Noselect`) || strings.EqualFold(a, `\NonExistent`) {
+			return "", false
+		}
+	}
+	// Skip the hierarchy delimiter: NIL, "/" or an escaped "\\".
+	switch {
+	case strings.HasPrefix(rest, `"\\" `):
+		rest = rest[5:]
+	case strings.HasPrefix(rest, `"`) && len(rest) > 4:
+		rest = rest[4:]
+	default:
+		_, rest, _ = strings.Cut(rest, " ")
+	}
+	name := rest
+	if unquoted, ok := strings.CutPrefix(name, `"`); ok {
+		name = strings.NewReplacer(`\\`, `\`, `\"`, `"`).Replace(strings.TrimSuffix(unquoted, `"`))
+	}
+	if name == "" || strings.HasPrefix(name, "{") { // literals are not supported
+		return "", false
+	}
+	return name, true
+}
+
+// keepsKeywords reports whether the untagged responses to SELECT allow new
+// keywords to be stored, which PERMANENTFLAGS announces with \*.
+func keepsKeywords(selected []string) bool {
+	for _, line := range selected {
+		if _, flags, ok := strings.Cut(line, "[PERMANENTFLAGS ("); ok {
+			flags, _, _ = strings.Cut(flags, ")")
+			return slices.Contains(strings.Fields(flags), `\*`)
+		}
+	}
+	return false
+}
+
+// login connects and logs in, returning the server's capabilities.
+func (m *imapMailbox) login(ctx context.Context) (*imapConn, []string, error) {
+	port := m.cfg.Port
+	if port == "" {
+		port = "993"
+	}
+	d := tls.Dialer{NetDialer: &net.Dialer{Timeout: imapTimeout}}
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(m.cfg.Host, port))
+	if err != nil {
+		return nil, nil, err
+	}
+	deadline := time.Now().Add(imapTimeout)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	conn.SetDeadline(deadline)
+
+	c := &imapConn{conn: conn, r: bufio.NewReader(conn)}
+	if _, err := c.r.ReadString('\n'); err != nil { // greeting
+		conn.Close()
+		return nil, nil, err
+	}
+	if _, err := c.cmd("LOGIN %s %s", quote(m.cfg.User), quote(m.cfg.Pass)); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	caps, err := c.cmd("CAPABILITY")
+	if err != nil {
+		c.close()
+		return nil, nil, err
+	}
+	return c, caps, nil
+}
+
+type imapConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+}
+
+// cmd sends a tagged command and returns the untagged responses that came
+// before its completion. Anything but OK is an error.
+func (c *imapConn) cmd(format string, args ...any) ([]string, error) {
+	c.tag++
+	tag := fmt.Sprintf("a%d", c.tag)
+	if _, err := fmt.Fprintf(c.conn, "%s %s\r\n", tag, fmt.Sprintf(format, args...)); err != nil {
+		return nil, err
+	}
+	var untagged []string
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		rest, ok := strings.CutPrefix(line, tag+" ")
+		if !ok {
+			untagged = append(untagged, line)
+			continue
+		}
+		if !strings.HasPrefix(rest, "OK") {
+			verb, _, _ := strings.Cut(format, " ")
+			return nil, fmt.Errorf("imap %s: %s", verb, rest)
+		}
+		return untagged, nil
+	}
+}
+
+// search returns the UID set of the selected folder's messages whose header
+// contains value, or "" when there are none.
+func (c *imapConn) search(header, value string) (string, error) {
+	found, err := c.cmd("UID SEARCH HEADER %s %s", header, quote(value))
+	if err != nil {
+		return "", err
+	}
+	var uids []string
+	for _, line := range found {
+		if rest, ok := strings.CutPrefix(line, "* SEARCH"); ok {
+			uids = append(uids, strings.Fields(rest)...)
+		}
+	}
+	return strings.Join(uids, ","), nil
+}
+
+func (c *imapConn) close() {
+	c.cmd("LOGOUT")
+	c.conn.Close()
+}
+
+// keyword makes an IMAP keyword of a label by dropping the characters atoms
+// may not contain.
+func keyword(label string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || strings.ContainsRune(`(){%*"\]`, r) {
+			return -1
+		}
+		return r
+	}, label)
+}
+
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func hasCapability(lines []string, capability string) bool {
+	for _, line := range lines {
+		if rest, ok := strings.CutPrefix(line, "* CAPABILITY "); ok {
+			for _, c := range strings.Fields(rest) {
+				if strings.EqualFold(c, capability) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package seeds
+
+import "testing"
+
+func TestListFolder(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+		ok   bool
+	}{
+		{`* LIST (\HasNoChildren) "/" "INBOX"`, "INBOX", true},
+		{`* LIST (\HasNoChildren \Junk) "/" "Junk"`, "Junk", true},
+		{`* LIST () "." Archive`, "Archive", true},
+		{`* LIST (\HasNoChildren) "/" "[Gmail]/All Mail"`, "[Gmail]/All Mail", true},
+		{`* LIST () "\\" "Sent \"Old\""`, `Sent "Old"`, true},
+		{`* LIST () NIL "Shared"`, "Shared", true},
+		{`* LIST (\Noselect \HasChildren) "/" "[Gmail]"`, "", false},
+		{`* LIST (\NonExistent) "/" "Gone"`, "", false},
+		{`* LIST () "/" {5}`, "", false},
+		{`* STATUS "INBOX" (MESSAGES 1)`, "", false},
+	}
+	for _, tc := range tests {
+		got, ok := listFolder(tc.line)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("listFolder(%q) = %q, %v; want %q, %v", tc.line, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestKeepsKeywords(t *testing.T) {
+	tests := []struct {
+		selected []string
+		want     bool
+	}{
+		{[]string{`* FLAGS (\Seen \Deleted)`, `* OK [PERMANENTFLAGS (\Seen \Deleted \*)] Limited`}, true},
+		{[]string{`* OK [PERMANENTFLAGS (\Seen \Deleted)] Limited`}, false},
+		{[]string{`* 3 EXISTS`}, false},
+	}
+	for _, tc := range tests {
+		if got := keepsKeywords(tc.selected); got != tc.want {
+			t.Errorf("keepsKeywords(%q) = %v, want %v", tc.selected, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package seeds
+
+import (
+	"context"
+	"errors"
+)
+
+// Mailbox acts on a seed's mailbox with the seed's own credentials.
+type Mailbox interface {
+	// Rescue looks for the message in the spam folder, moves it to the inbox
+	// and marks it read, important and starred. It reports false when the
+	// message is not in spam.
+	Rescue(ctx context.Context, messageID string) (bool, error)
+	// Label finds the warmup message by its Message-ID, wherever it is
+	// filed, and applies the label to it. It reports false when it is not
+	// found, and ErrCannotLabel when the mailbox cannot label it.
+	Label(ctx context.Context, messageID, label string) (bool, error)
+}
+
+// ErrCannotLabel is returned by Mailbox.Label when the message was found but
+// the mailbox does not support labels where it is filed.
+var ErrCannotLabel = errors.New("mailbox cannot label the message")
+
+// Open returns the mailbox of the seed according to its access.
+func Open(ctx context.Context, s Seed) (Mailbox, error) {
+	switch s.Access {
+	case "google":
+		return openGmail(ctx, s.Google)
+	case "outlook":
+		return openGraph(ctx, s.Outlook), nil
+	case "smtp":
+		if s.IMAP.Host == "" {
+			return nil, errors.New("seed " + s.Address + " has no IMAP access")
+		}
+		return &imapMailbox{cfg: s.IMAP}, nil
+	}
+	return nil, errors.New("unknown seed access: " + s.Access)
+}
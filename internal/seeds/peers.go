@@ -0,0 +1,178 @@
+package seeds
+
+import (
+	"context"
+	"log"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/ilivestrong/email_warmup_service/internal/quota"
+	"github.com/ilivestrong/email_warmup_service/internal/resolver"
+)
+
+// PeerPool lets opted-in tenants warm each other up: their sender mailboxes
+// receive each other's warmup mail. Mail is never paired within a tenant or a
+// domain, goes to the peer that has received least relative to what it sent,
+// and is capped per sender and recipient pair per day.
+type PeerPool struct {
+	tenants []string
+	senders resolver.Resolver
+	ledger  Ledger
+	pairCap int
+}
+
+func NewPeerPool(tenants []string, senders resolver.Resolver, ledger Ledger, pairCap int) *PeerPool {
+	return &PeerPool{tenants: tenants, senders: senders, ledger: ledger, pairCap: pairCap}
+}
+
+func (p *PeerPool) Joined(tenantID string) bool { return slices.Contains(p.tenants, tenantID) }
+
+// Pick returns a peer mailbox to receive a send planned from the tenant's
+// sender on date, and records the send, or reports false when no peer is
+// eligible. Nothing is recorded for a send that goes to a seed instead.
+func (p *PeerPool) Pick(ctx context.Context, tenantID, from, date string) (string, bool, error) {
+	from = strings.ToLower(from)
+	counts, err := p.ledger.Counts(ctx, date)
+	if err != nil {
+		return "", false, err
+	}
+	domain := quota.SenderScope("", from).Domain
+
+	var best []string
+	bestBalance := 0
+	for _, peerTenant := range p.tenants {
+		if peerTenant == tenantID {
+			continue
+		}
+		addrs, err := p.senders.Senders(ctx, peerTenant)
+		if err != nil {
+			log.Printf("leaving peer tenant %s out of the pool: %v", peerTenant, err)
+			continue
+		}
+		for _, addr := range addrs {
+			addr = strings.ToLower(addr)
+			if quota.SenderScope("", addr).Domain == domain || counts[pairKey(from, addr)] >= p.pairCap {
+				continue
+			}
+			balance := counts[recvKey(addr)] - counts[sentKey(addr)]
+			switch {
+			case len(best) == 0 || balance < bestBalance:
+				best, bestBalance = []string{addr}, balance
+			case balance == bestBalance:
+				best = append(best, addr)
+			}
+		}
+	}
+	if len(best) == 0 {
+		return "", false, nil
+	}
+	to := best[rand.IntN(len(best))]
+	if err := p.ledger.Add(ctx, date, sentKey(from), recvKey(to), pairKey(from, to)); err != nil {
+		return "", false, err
+	}
+	return to, true, nil
+}
+
+func sentKey(addr string) string     { return "sent:" + addr }
+func recvKey(addr string) string     { return "recv:" + addr }
+func pairKey(from, to string) string { return "pair:" + from + ">" + to }
+
+// Ledger counts the peer pool's planned traffic per day.
+type Ledger interface {
+	Add(ctx context.Context, date string, keys ...string) error
+	Counts(ctx context.Context, date string) (map[string]int, error)
+}
+
+// ledgerTTL keeps a day's counts around while tenants in later timezones are
+// still planning it.
+const ledgerTTL = 48 * time.Hour
+
+// NewLedger keeps counts in the Redis hash "peers:<date>", or in process
+// memory when redisURL is empty.
+func NewLedger(redisURL string) (Ledger, error) {
+	if redisURL == "" {
+		return &memoryLedger{days: map[string]*ledgerDay{}}, nil
+	}
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	return &redisLedger{rdb: redis.NewClient(opts)}, nil
+}
+
+type redisLedger struct {
+	rdb *redis.Client
+}
+
+func (l *redisLedger) Add(ctx context.Context, date string, keys ...string) error {
+	key := "peers:" + date
+	pipe := l.rdb.TxPipeline()
+	for _, k := range keys {
+		pipe.HIncrBy(ctx, key, k, 1)
+	}
+	pipe.Expire(ctx, key, ledgerTTL)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (l *redisLedger) Counts(ctx context.Context, date string) (map[string]int, error) {
+	m, err := l.rdb.HGetAll(ctx, "peers:"+date).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]int, len(m))
+	for k, v := range m {
+		n, _ := strconv.Atoi(v)
+		out[k] = n
+	}
+	return out, nil
+}
+
+// memoryLedger expires days like the Redis hashes do, ledgerTTL after their
+// last write.
+type memoryLedger struct {
+	mu   sync.Mutex
+	days map[string]*ledgerDay
+}
+
+type ledgerDay struct {
+	counts  map[string]int
+	expires time.Time
+}
+
+func (l *memoryLedger) Add(_ context.Context, date string, keys ...string) error {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for d, day := range l.days {
+		if !now.Before(day.expires) {
+			delete(l.days, d)
+		}
+	}
+	day := l.days[date]
+	if day == nil {
+		day = &ledgerDay{counts: map[string]int{}}
+		l.days[date] = day
+	}
+	for _, k := range keys {
+		day.counts[k]++
+	}
+	day.expires = now.Add(ledgerTTL)
+	return nil
+}
+
+func (l *memoryLedger) Counts(_ context.Context, date string) (map[string]int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	day := l.days[date]
+	if day == nil || !time.Now().Before(day.expires) {
+		return map[string]int{}, nil
+	}
+	return maps.Clone(day.counts), nil
+}
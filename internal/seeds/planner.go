@@ -0,0 +1,138 @@
+package seeds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ilivestrong/email_warmup_service/internal/clock"
+	"github.com/ilivestrong/email_warmup_service/internal/content"
+	"github.com/ilivestrong/email_warmup_service/internal/queue"
+	"github.com/ilivestrong/email_warmup_service/internal/queue/events"
+	"github.com/ilivestrong/email_warmup_service/internal/quota"
+	"github.com/ilivestrong/email_warmup_service/internal/resolver"
+)
+
+// Planner generates a tenant's warmup traffic to the seed pool and, for
+// tenants that joined it, the peer pool.
+type Planner struct {
+	registry Registry
+	peers    *PeerPool
+	store    quota.Store
+	senders  resolver.Resolver
+	tc       *clock.Tenants
+	qc       queue.Client
+	gen      *content.Generator
+}
+
+func NewPlanner(registry Registry, peers *PeerPool, store quota.Store, senders resolver.Resolver, tc *clock.Tenants, qc queue.Client, gen *content.Generator) *Planner {
+	return &Planner{registry: registry, peers: peers, store: store, senders: senders, tc: tc, qc: qc, gen: gen}
+}
+
+// Plan spends the quota each of the tenant's senders has left on the local
+// day of at, without overdrawing the shared domain and tenant quotas. Every
+// send goes at a random time between now and the end of the day to a peer
+// when the tenant is in the peer pool and one is eligible, and to a random
+// seed otherwise. A day that has already ended, as when a missed run is
+// caught up, is not planned. Event IDs are derived from the day, sender and
+// sequence number, so planning the same day twice does not send twice.
+func (p *Planner) Plan(ctx context.Context, tenantID string, at time.Time) error {
+	now := p.tc.Now(tenantID)
+	date := p.tc.Date(tenantID, at, 0)
+	if date != p.tc.Date(tenantID, now, 0) {
+		log.Printf("not planning warmup for tenant %s on %s, the day has ended", tenantID, date)
+		return nil
+	}
+
+	pool, err := p.registry.Seeds(ctx)
+	if err != nil {
+		return err
+	}
+	senders, err := p.senders.Senders(ctx, tenantID)
+	if err != nil {
+		return err
+	}
+	var seeds []Seed
+	for _, s := range pool {
+		if !slices.ContainsFunc(senders, func(a string) bool { return strings.EqualFold(a, s.Address) }) {
+			seeds = append(seeds, s)
+		}
+	}
+	joined := p.peers.Joined(tenantID)
+	if len(seeds) == 0 && !joined {
+		log.Printf("no seeds to plan warmup for tenant %s", tenantID)
+		return nil
+	}
+
+	// Tenants plan in their own timezones; peers share one UTC ledger day.
+	ledgerDate := now.UTC().Format(clock.DateLayout)
+	window := p.tc.NextMidnight(tenantID, now).Sub(now)
+	initial := quota.InitialQuota(senders)
+	planned := map[quota.Scope]int{}
+	total := 0
+	for _, addr := range senders {
+		sender := quota.SenderScope(tenantID, addr)
+		n := -1
+		for _, s := range sender.Rollups() {
+			if _, err := p.store.InitQuota(ctx, s, date, initial(s)); err != nil {
+				return err
+			}
+			left, err := p.store.GetRemainingQuota(ctx, s, date)
+			if err != nil {
+				return err
+			}
+			if left -= planned[s]; n < 0 || left < n {
+				n = left
+			}
+		}
+		for _, s := range sender.Rollups() {
+			planned[s] += max(n, 0)
+		}
+
+		for i := 0; i < n; i++ {
+			to, ok := "", false
+			if joined {
+				if to, ok, err = p.peers.Pick(ctx, tenantID, addr, ledgerDate); err != nil {
+					return err
+				}
+			}
+			if !ok {
+				if len(seeds) == 0 {
+					break
+				}
+				to = seeds[rand.IntN(len(seeds))].Address
+			}
+			sendAt := now.Add(time.Duration(rand.Int64N(int64(window))))
+			msg, err := p.gen.Warmup(ctx, content.VarsFor(addr, to, sendAt))
+			if errors.Is(err, content.ErrNotUnique) {
+				log.Printf("skipping warmup send from %s: %v", addr, err)
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("generating warmup content for %s: %w", addr, err)
+			}
+			ev := &events.SendEmailEvent{
+				EventID:   fmt.Sprintf("seed:%s:%s:%s:%d", tenantID, date, sender.Sender, i),
+				TenantID:  tenantID,
+				From:      addr,
+				ToAddress: to,
+				Subject:   msg.Subject,
+				Body:      msg.Text,
+				HTMLBody:  msg.HTML,
+				SendAt:    &sendAt,
+				Priority:  events.PriorityWarmup,
+			}
+			if err := p.qc.Publish(ctx, ev); err != nil {
+				return fmt.Errorf("publishing warmup send from %s: %w", addr, err)
+			}
+			total++
+		}
+	}
+	log.Printf("planned %d warmup sends for tenant %s on %s", total, tenantID, date)
+	return nil
+}
@@ -0,0 +1,86 @@
+package seeds
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ilivestrong/email_warmup_service/internal/delay"
+	"github.com/ilivestrong/email_warmup_service/internal/providers"
+	"github.com/ilivestrong/email_warmup_service/internal/queue/events"
+)
+
+// Rescuer has seed mailboxes pull warmup mail out of spam.
+type Rescuer struct {
+	registry Registry
+	after    time.Duration
+	delayed  delay.Store
+	label    string
+}
+
+// NewRescuer checks the seed's spam folder the given time after each send.
+// Rescued and inbox mail is then filed under label.
+func NewRescuer(registry Registry, after time.Duration, delayed delay.Store, label string) *Rescuer {
+	return &Rescuer{registry: registry, after: after, delayed: delayed, label: label}
+}
+
+// Plan parks a rescue check of msg when it was sent to a seed. It reports
+// whether one was planned.
+func (r *Rescuer) Plan(ctx context.Context, tenantID string, msg *providers.Message) (bool, error) {
+	seed, ok, err := Find(ctx, r.registry, msg.To)
+	if err != nil || !ok {
+		return false, err
+	}
+	at := time.Now().Add(r.after)
+	check := &events.SendEmailEvent{
+		Version:   events.CurrentVersion,
+		Kind:      events.KindSeedRescue,
+		EventID:   "rescue:" + msg.MessageID,
+		TenantID:  tenantID,
+		From:      seed.Address,
+		ToAddress: msg.From,
+		Subject:   msg.Subject,
+		InReplyTo: msg.MessageID,
+		SendAt:    &at,
+		Priority:  events.PriorityEngagement,
+	}
+	if err := r.delayed.Schedule(ctx, check); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Rescue moves the message named by a rescue event out of the seed's spam
+// folder. It reports whether the message was in spam.
+func (r *Rescuer) Rescue(ctx context.Context, ev *events.SendEmailEvent) (bool, error) {
+	seed, ok, err := Find(ctx, r.registry, ev.From)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, fmt.Errorf("%s is not a seed mailbox", ev.From)
+	}
+	mb, err := Open(ctx, seed)
+	if err != nil {
+		return false, err
+	}
+	return mb.Rescue(ctx, ev.InReplyTo)
+}
+
+// Label files the warmup message named by a rescue event under the label in
+// the mailbox of the seed. It reports whether the message was labelled, and
+// false without looking when no label is configured.
+func (r *Rescuer) Label(ctx context.Context, ev *events.SendEmailEvent) (bool, error) {
+	if r.label == "" {
+		return false, nil
+	}
+	seed, ok, err := Find(ctx, r.registry, ev.From)
+	if err != nil || !ok {
+		return false, err
+	}
+	mb, err := Open(ctx, seed)
+	if err != nil {
+		return false, err
+	}
+	return mb.Label(ctx, ev.InReplyTo, r.label)
+}
@@ -0,0 +1,111 @@
+package seeds
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/ilivestrong/email_warmup_service/internal/content"
+	"github.com/ilivestrong/email_warmup_service/internal/delay"
+	"github.com/ilivestrong/email_warmup_service/internal/providers"
+	"github.com/ilivestrong/email_warmup_service/internal/queue/events"
+	"github.com/ilivestrong/email_warmup_service/internal/quota"
+)
+
+// Responder has seed mailboxes answer part of the warmup mail they receive,
+// so that conversation threads form.
+type Responder struct {
+	registry           Registry
+	rate               float64
+	minDelay, maxDelay time.Duration
+	delayed            delay.Store
+	gen                *content.Generator
+}
+
+// NewResponder replies to the given fraction of warmup mail, after a random
+// delay between minDelay and maxDelay.
+func NewResponder(registry Registry, rate float64, minDelay, maxDelay time.Duration, delayed delay.Store, gen *content.Generator) *Responder {
+	return &Responder{registry: registry, rate: rate, minDelay: minDelay, maxDelay: max(minDelay, maxDelay), delayed: delayed, gen: gen}
+}
+
+// Plan decides whether the seed msg was sent to replies to it and, if so,
+// parks the reply until it is due. It reports whether a reply was planned.
+func (r *Responder) Plan(ctx context.Context, tenantID string, msg *providers.Message) (bool, error) {
+	seed, ok, err := Find(ctx, r.registry, msg.To)
+	if err != nil || !ok || !seed.CanReply() || rand.Float64() >= r.rate {
+		return false, err
+	}
+	sendAt := time.Now().Add(r.minDelay)
+	if spread := r.maxDelay - r.minDelay; spread > 0 {
+		sendAt = sendAt.Add(time.Duration(rand.Int64N(int64(spread))))
+	}
+	subject := msg.Subject
+	if !strings.HasPrefix(strings.ToLower(subject), "re:") {
+		subject = "Re: " + subject
+	}
+	body, err := r.gen.Reply(ctx, content.VarsFor(seed.Address, msg.From, sendAt))
+	if err != nil {
+		return false, err
+	}
+	reply := &events.SendEmailEvent{
+		Version:    events.CurrentVersion,
+		Kind:       events.KindSeedReply,
+		EventID:    "reply:" + msg.MessageID,
+		TenantID:   tenantID,
+		From:       seed.Address,
+		ToAddress:  msg.From,
+		Subject:    subject,
+		Body:       body.Text,
+		InReplyTo:  msg.MessageID,
+		References: msg.References,
+		SendAt:     &sendAt,
+		Priority:   events.PriorityEngagement,
+	}
+	if err := r.delayed.Schedule(ctx, reply); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Send delivers a seed reply from the seed's own mailbox and returns the
+// message sent.
+func (r *Responder) Send(ctx context.Context, ev *events.SendEmailEvent) (*providers.Message, error) {
+	seed, ok, err := Find(ctx, r.registry, ev.From)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%s is not a seed mailbox", ev.From)
+	}
+	prov, err := providers.New(seed.Access, seed.SMTP, seed.Google)
+	if err != nil {
+		return nil, err
+	}
+	msg := &providers.Message{
+		From:       seed.Address,
+		To:         ev.ToAddress,
+		Subject:    ev.Subject,
+		Text:       ev.Body,
+		HTML:       ev.HTMLBody,
+		MessageID:  providers.MessageID(ev.EventID, quota.SenderScope("", seed.Address).Domain),
+		InReplyTo:  ev.InReplyTo,
+		References: ev.References,
+	}
+	return msg, prov.Send(ctx, msg)
+}
+
+// Find looks a seed up by address.
+func Find(ctx context.Context, registry Registry, address string) (Seed, bool, error) {
+	pool, err := registry.Seeds(ctx)
+	if err != nil {
+		return Seed{}, false, err
+	}
+	for _, s := range pool {
+		if strings.EqualFold(s.Address, address) {
+			return s, true, nil
+		}
+	}
+	return Seed{}, false, nil
+}
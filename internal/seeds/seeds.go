@@ -0,0 +1,120 @@
+package seeds
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+
+	"github.com/ilivestrong/email_warmup_service/internal/config"
+	"golang.org/x/oauth2/google"
+)
+
+// Seed is a recipient mailbox of the warmup network. Its credentials let the
+// service act as the seed, e.g. to open, reply to or rescue warmup mail.
+type Seed struct {
+	Address string `json:"address"`
+	// Access names how the mailbox is reached: smtp (with IMAP for reading),
+	// google or outlook.
+	Access  string                   `json:"access"`
+	SMTP    config.SMTPConfig        `json:"smtp"`
+	IMAP    IMAPConfig               `json:"imap"`
+	Google  config.GoogleOAuthConfig `json:"google"`
+	Outlook OutlookConfig            `json:"outlook"`
+}
+
+// CanReply reports whether mail can be sent as the seed. Outlook seeds are
+// only acted on through Graph, which has no sending provider yet.
+func (s Seed) CanReply() bool { return s.Access == "smtp" || s.Access == "google" }
+
+// OutlookConfig reaches a seed's mailbox through Microsoft Graph with an
+// Azure AD app's credentials and the seed's refresh token. Tenant is the
+// Azure AD tenant and defaults to "common".
+type OutlookConfig struct {
+	Tenant       string `json:"tenant"`
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+// IMAPConfig reaches a seed's mailbox over IMAPS. SpamFolder defaults to
+// "Junk".
+type IMAPConfig struct {
+	Host       string `json:"host"`
+	Port       string `json:"port"`
+	User       string `json:"user"`
+	Pass       string `json:"pass"`
+	SpamFolder string `json:"spamFolder"`
+}
+
+// Registry lists the seed mailboxes.
+type Registry interface {
+	Seeds(ctx context.Context) ([]Seed, error)
+}
+
+type Static struct{ seeds []Seed }
+
+// NewStatic validates seeds and serves them as they are.
+func NewStatic(seeds []Seed) (*Static, error) {
+	seen := map[string]bool{}
+	for i, s := range seeds {
+		if _, err := mail.ParseAddress(s.Address); err != nil {
+			return nil, fmt.Errorf("seed %d: %w", i, err)
+		}
+		key := strings.ToLower(s.Address)
+		if seen[key] {
+			return nil, fmt.Errorf("seed %s listed twice", s.Address)
+		}
+		seen[key] = true
+		if err := s.check(); err != nil {
+			return nil, fmt.Errorf("seed %s: %w", s.Address, err)
+		}
+	}
+	return &Static{seeds: seeds}, nil
+}
+
+// check validates the credentials the seed's access needs, so that a bad
+// seed fails at startup rather than on first use.
+func (s Seed) check() error {
+	switch s.Access {
+	case "smtp":
+		if s.SMTP.Host == "" {
+			return errors.New("smtp access needs smtp.Host")
+		}
+	case "google":
+		if _, err := google.ConfigFromJSON([]byte(s.Google.GoogleCredentialsJSON)); err != nil {
+			return fmt.Errorf("google.GoogleCredentialsJSON: %w", err)
+		}
+		if s.Google.GoogleRefreshToken == "" {
+			return errors.New("google access needs google.GoogleRefreshToken")
+		}
+	case "outlook":
+		if s.Outlook.ClientID == "" || s.Outlook.RefreshToken == "" {
+			return errors.New("outlook access needs outlook.clientId and outlook.refreshToken")
+		}
+	default:
+		return fmt.Errorf("unknown access %q", s.Access)
+	}
+	return nil
+}
+
+// LoadFile reads a JSON array of seeds. An empty path gives an empty pool.
+func LoadFile(path string) (*Static, error) {
+	if path == "" {
+		return &Static{}, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var seeds []Seed
+	if err := json.Unmarshal(b, &seeds); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return NewStatic(seeds)
+}
+
+func (s *Static) Seeds(context.Context) ([]Seed, error) { return s.seeds, nil }
@@ -0,0 +1,68 @@
+// Package tracking stamps warmup mail with a code that identifies it as the
+// service's, so that seed mailboxes can label it and reconciliation can find
+// it without matching on the subject.
+package tracking
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"strings"
+
+	"github.com/ilivestrong/email_warmup_service/internal/providers"
+)
+
+// codePrefix starts every code, so codes read as one searchable word.
+const codePrefix = "wu"
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Tagger derives tracking codes. Each tenant has its own key, derived from
+// the service secret, so codes cannot be forged or linked across tenants.
+type Tagger struct {
+	secret   []byte
+	bodyCode bool
+}
+
+// New returns a tagger for the secret; an empty secret disables tracking.
+// With bodyCode the code is also appended to the message bodies, so that it
+// can be searched for where headers cannot. The appended line is the same in
+// every message, which content filters may learn, so it is off by default.
+func New(secret string, bodyCode bool) *Tagger {
+	return &Tagger{secret: []byte(secret), bodyCode: bodyCode}
+}
+
+// Code returns the tracking code of the tenant's message, or "" when
+// tracking is disabled.
+func (t *Tagger) Code(tenantID, messageID string) string {
+	if len(t.secret) == 0 {
+		return ""
+	}
+	key := mac(t.secret, "tenant:"+tenantID)
+	return codePrefix + strings.ToLower(encoding.EncodeToString(mac(key, messageID)[:10]))
+}
+
+// Stamp sets the tracking code of msg, which must already have its
+// Message-ID.
+func (t *Tagger) Stamp(tenantID string, msg *providers.Message) {
+	code := t.Code(tenantID, msg.MessageID)
+	if code == "" {
+		return
+	}
+	msg.Tag = code
+	if !t.bodyCode {
+		return
+	}
+	if msg.Text != "" {
+		msg.Text += "\n\nRef: " + code
+	}
+	if msg.HTML != "" {
+		msg.HTML += "<p>Ref: " + code + "</p>"
+	}
+}
+
+func mac(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
@@ -0,0 +1,116 @@
+package validator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ResultCache keeps ZeroBounce results per address, so that addresses mailed
+// every day do not cost a credit each time.
+type ResultCache interface {
+	Get(ctx context.Context, address string) (ZeroBounceResult, bool, error)
+	Set(ctx context.Context, address string, res ZeroBounceResult, ttl time.Duration) error
+}
+
+// NewResultCache returns a Redis cache for redis:// and rediss:// URLs and a
+// process-local one for memory:// or an empty URL.
+func NewResultCache(cacheURL string) (ResultCache, error) {
+	if cacheURL == "" {
+		return newMemoryCache(), nil
+	}
+	u, err := url.Parse(cacheURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "redis", "rediss":
+		opts, err := redis.ParseURL(cacheURL)
+		if err != nil {
+			return nil, err
+		}
+		return &redisCache{rdb: redis.NewClient(opts)}, nil
+	case "memory":
+		return newMemoryCache(), nil
+	}
+	return nil, fmt.Errorf("unsupported zerobounce cache URL scheme %q", u.Scheme)
+}
+
+func cacheKey(address string) string { return "zerobounce:" + strings.ToLower(address) }
+
+type redisCache struct {
+	rdb *redis.Client
+}
+
+func (c *redisCache) Get(ctx context.Context, address string) (ZeroBounceResult, bool, error) {
+	var res ZeroBounceResult
+	b, err := c.rdb.Get(ctx, cacheKey(address)).Bytes()
+	if err == redis.Nil {
+		return res, false, nil
+	}
+	if err != nil {
+		return res, false, err
+	}
+	if err := json.Unmarshal(b, &res); err != nil {
+		return ZeroBounceResult{}, false, fmt.Errorf("decoding cached result for %s: %w", address, err)
+	}
+	return res, true, nil
+}
+
+func (c *redisCache) Set(ctx context.Context, address string, res ZeroBounceResult, ttl time.Duration) error {
+	b, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	return c.rdb.Set(ctx, cacheKey(address), b, ttl).Err()
+}
+
+type memoryCache struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	swept   time.Time
+}
+
+type memoryEntry struct {
+	res     ZeroBounceResult
+	expires time.Time
+}
+
+// cacheSweepEvery bounds how often expired results are dropped from memory.
+const cacheSweepEvery = time.Hour
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{entries: map[string]memoryEntry{}}
+}
+
+func (c *memoryCache) Get(_ context.Context, address string) (ZeroBounceResult, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[cacheKey(address)]
+	if !ok || !time.Now().Before(e.expires) {
+		return ZeroBounceResult{}, false, nil
+	}
+	return e.res, true, nil
+}
+
+func (c *memoryCache) Set(_ context.Context, address string, res ZeroBounceResult, ttl time.Duration) error {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.swept) >= cacheSweepEvery {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.swept = now
+	}
+	c.entries[cacheKey(address)] = memoryEntry{res: res, expires: now.Add(ttl)}
+	return nil
+}
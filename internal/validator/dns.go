@@ -0,0 +1,95 @@
+package validator
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DNSResolver is satisfied by *net.Resolver.
+type DNSResolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// DNS checks that the recipient's domain accepts mail: it must have MX
+// records, or failing that an address record to serve as the implicit MX
+// (RFC 5321 section 5.1), and must not publish a null MX (RFC 7505).
+// Conclusive results are cached per domain for ttl; lookup failures are
+// unknown and not cached.
+func DNS(r DNSResolver, ttl time.Duration) Check {
+	return &dnsCheck{r: r, ttl: ttl, now: time.Now, cache: map[string]dnsEntry{}}
+}
+
+type dnsCheck struct {
+	r     DNSResolver
+	ttl   time.Duration
+	now   func() time.Time
+	mu    sync.Mutex
+	cache map[string]dnsEntry
+}
+
+type dnsEntry struct {
+	verdict Verdict
+	expires time.Time
+}
+
+func (c *dnsCheck) Name() string { return "dns" }
+
+func (c *dnsCheck) Check(ctx context.Context, _, address string) Verdict {
+	domain := strings.ToLower(address[strings.LastIndexByte(address, '@')+1:])
+	now := c.now()
+	c.mu.Lock()
+	e, ok := c.cache[domain]
+	c.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.verdict
+	}
+
+	v := c.lookup(ctx, domain)
+	if v.Status == Unknown {
+		return v
+	}
+	c.mu.Lock()
+	for d, e := range c.cache {
+		if !now.Before(e.expires) {
+			delete(c.cache, d)
+		}
+	}
+	c.cache[domain] = dnsEntry{verdict: v, expires: now.Add(c.ttl)}
+	c.mu.Unlock()
+	return v
+}
+
+func (c *dnsCheck) lookup(ctx context.Context, domain string) Verdict {
+	records, err := c.r.LookupMX(ctx, domain)
+	if err == nil && len(records) > 0 {
+		if len(records) == 1 && (records[0].Host == "." || records[0].Host == "") {
+			return Verdict{Status: Invalid, Reason: "null_mx", Detail: domain}
+		}
+		return Verdict{Status: Valid}
+	}
+	if err != nil && !notFound(err) {
+		return Verdict{Status: Unknown, Reason: "dns_error", Detail: err.Error()}
+	}
+
+	addrs, err := c.r.LookupIPAddr(ctx, domain)
+	switch {
+	case err == nil && len(addrs) > 0:
+		return Verdict{Status: Valid, Reason: "implicit_mx"}
+	case err == nil || notFound(err):
+		return Verdict{Status: Invalid, Reason: "no_mail_domain", Detail: domain}
+	default:
+		return Verdict{Status: Unknown, Reason: "dns_error", Detail: err.Error()}
+	}
+}
+
+// notFound reports whether err says the name or record does not exist, as
+// opposed to the lookup failing.
+func notFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
@@ -0,0 +1,282 @@
+package validator
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeResolver answers from fixed records and counts lookups per domain.
+type fakeResolver struct {
+	mx    map[string][]*net.MX
+	ips   map[string][]net.IPAddr
+	errs  map[string]error // returned by both lookups
+	calls map[string]int
+}
+
+func (r *fakeResolver) LookupMX(_ context.Context, name string) ([]*net.MX, error) {
+	r.calls[name]++
+	if err := r.errs[name]; err != nil {
+		return nil, err
+	}
+	if mx, ok := r.mx[name]; ok {
+		return mx, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r *fakeResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	if err := r.errs[host]; err != nil {
+		return nil, err
+	}
+	if ips, ok := r.ips[host]; ok {
+		return ips, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func newFakeResolver() *fakeResolver {
+	return &fakeResolver{
+		mx: map[string][]*net.MX{
+			"mail.test": {{Host: "mx1.mail.test.", Pref: 10}, {Host: "mx2.mail.test.", Pref: 20}},
+			"null.test": {{Host: ".", Pref: 0}},
+		},
+		ips: map[string][]net.IPAddr{
+			"implicit.test": {{IP: net.ParseIP("192.0.2.1")}},
+			"v6.test":       {{IP: net.ParseIP("2001:db8::1")}},
+		},
+		errs: map[string]error{
+			"servfail.test": &net.DNSError{Err: "server misbehaving", Name: "servfail.test", IsTemporary: true},
+		},
+		calls: map[string]int{},
+	}
+}
+
+func TestDNSCheck(t *testing.T) {
+	tests := []struct {
+		address string
+		status  Status
+		reason  string
+	}{
+		{"a@mail.test", Valid, ""},
+		{"a@MAIL.test", Valid, ""},
+		{"a@null.test", Invalid, "null_mx"},
+		{"a@implicit.test", Valid, "implicit_mx"},
+		{"a@v6.test", Valid, "implicit_mx"},
+		{"a@nxdomain.test", Invalid, "no_mail_domain"},
+		{"a@servfail.test", Unknown, "dns_error"},
+	}
+	c := DNS(newFakeResolver(), time.Hour)
+	for _, tt := range tests {
+		v := c.Check(context.Background(), "t1", tt.address)
+		if v.Status != tt.status || v.Reason != tt.reason {
+			t.Errorf("%s: got %s/%s, want %s/%s", tt.address, v.Status, v.Reason, tt.status, tt.reason)
+		}
+	}
+}
+
+func TestDNSCheckCache(t *testing.T) {
+	r := newFakeResolver()
+	now := time.Date(2025, 1, 2, 9, 0, 0, 0, time.UTC)
+	c := DNS(r, time.Hour).(*dnsCheck)
+	c.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	c.Check(ctx, "t1", "a@mail.test")
+	c.Check(ctx, "t1", "b@mail.test")
+	if n := r.calls["mail.test"]; n != 1 {
+		t.Fatalf("lookups within ttl = %d, want 1", n)
+	}
+
+	now = now.Add(time.Hour)
+	c.Check(ctx, "t1", "a@mail.test")
+	if n := r.calls["mail.test"]; n != 2 {
+		t.Fatalf("lookups after ttl = %d, want 2", n)
+	}
+
+	c.Check(ctx, "t1", "a@servfail.test")
+	c.Check(ctx, "t1", "a@servfail.test")
+	if n := r.calls["servfail.test"]; n != 2 {
+		t.Fatalf("failed lookups = %d, want 2 as failures are not cached", n)
+	}
+
+	// A domain that starts resolving is picked up once the failure clears.
+	delete(r.errs, "servfail.test")
+	r.mx["servfail.test"] = []*net.MX{{Host: "mx.servfail.test.", Pref: 10}}
+	if v := c.Check(ctx, "t1", "a@servfail.test"); v.Status != Valid {
+		t.Fatalf("after recovery got %s, want valid", v.Status)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	if notFound(errors.New("no such host")) {
+		t.Error("plain error taken for NXDOMAIN")
+	}
+	if !notFound(&net.DNSError{IsNotFound: true}) {
+		t.Error("NXDOMAIN not recognised")
+	}
+}
+
+// zone is what stubDNS knows about a name; names it does not know are
+// NXDOMAIN.
+type zone struct {
+	rcode byte
+	mx    []*net.MX
+	ips   []net.IP
+}
+
+const (
+	typeA    = 1
+	typeMX   = 15
+	typeAAAA = 28
+)
+
+// stubDNS serves hand-encoded answers over UDP on the loopback interface and
+// returns a Go resolver that sends every query to it, so a check can be run
+// against the standard library's own message parsing.
+func stubDNS(t *testing.T, zones map[string]zone) *net.Resolver {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if resp := answer(buf[:n], zones); resp != nil {
+				pc.WriteTo(resp, addr)
+			}
+		}
+	}()
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", pc.LocalAddr().String())
+		},
+	}
+}
+
+// answer builds the response to a single-question query. A name under a
+// zone is answered as the zone itself, so search domains from the host's
+// resolv.conf do not change the outcome.
+func answer(q []byte, zones map[string]zone) []byte {
+	if len(q) < 12 || binary.BigEndian.Uint16(q[4:]) != 1 {
+		return nil
+	}
+	var labels []string
+	i := 12
+	for i < len(q) && q[i] != 0 {
+		l := int(q[i])
+		if i+1+l > len(q) {
+			return nil
+		}
+		labels = append(labels, string(q[i+1:i+1+l]))
+		i += 1 + l
+	}
+	if i+5 > len(q) {
+		return nil
+	}
+	question := q[12 : i+5]
+	qtype := binary.BigEndian.Uint16(q[i+1:])
+	name := strings.ToLower(strings.Join(labels, "."))
+
+	z, ok := zone{rcode: 3}, false
+	for n, zz := range zones {
+		if name == n || strings.HasPrefix(name, n+".") {
+			z, ok = zz, true
+			break
+		}
+	}
+	var rrs [][]byte
+	if ok && z.rcode == 0 {
+		switch qtype {
+		case typeMX:
+			for _, mx := range z.mx {
+				rdata := binary.BigEndian.AppendUint16(nil, mx.Pref)
+				rrs = append(rrs, rr(typeMX, append(rdata, encodeName(mx.Host)...)))
+			}
+		case typeA, typeAAAA:
+			for _, ip := range z.ips {
+				if v4 := ip.To4(); v4 != nil && qtype == typeA {
+					rrs = append(rrs, rr(typeA, v4))
+				} else if v4 == nil && qtype == typeAAAA {
+					rrs = append(rrs, rr(typeAAAA, ip.To16()))
+				}
+			}
+		}
+	}
+
+	resp := append([]byte(nil), q[:2]...)         // ID
+	resp = append(resp, 0x81, 0x80|z.rcode)       // QR, RD, RA
+	resp = binary.BigEndian.AppendUint16(resp, 1) // QDCOUNT
+	resp = binary.BigEndian.AppendUint16(resp, uint16(len(rrs)))
+	resp = append(resp, 0, 0, 0, 0) // NSCOUNT, ARCOUNT
+	resp = append(resp, question...)
+	for _, r := range rrs {
+		resp = append(resp, r...)
+	}
+	return resp
+}
+
+// rr encodes a resource record owned by the question name.
+func rr(typ uint16, rdata []byte) []byte {
+	b := []byte{0xc0, 12} // pointer to the question name
+	b = binary.BigEndian.AppendUint16(b, typ)
+	b = binary.BigEndian.AppendUint16(b, 1) // IN
+	b = binary.BigEndian.AppendUint32(b, 300)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(rdata)))
+	return append(b, rdata...)
+}
+
+func encodeName(name string) []byte {
+	var b []byte
+	for _, l := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if l != "" {
+			b = append(append(b, byte(len(l))), l...)
+		}
+	}
+	return append(b, 0)
+}
+
+func TestDNSCheckWire(t *testing.T) {
+	r := stubDNS(t, map[string]zone{
+		"mail.test":     {mx: []*net.MX{{Host: "mx1.mail.test.", Pref: 10}, {Host: "mx2.mail.test.", Pref: 20}}},
+		"null.test":     {mx: []*net.MX{{Host: ".", Pref: 0}}},
+		"implicit.test": {ips: []net.IP{net.ParseIP("192.0.2.1")}},
+		"v6.test":       {ips: []net.IP{net.ParseIP("2001:db8::1")}},
+		"nodata.test":   {},
+		"servfail.test": {rcode: 2},
+	})
+	tests := []struct {
+		address string
+		status  Status
+		reason  string
+	}{
+		{"a@mail.test", Valid, ""},
+		{"a@null.test", Invalid, "null_mx"},
+		{"a@implicit.test", Valid, "implicit_mx"},
+		{"a@v6.test", Valid, "implicit_mx"},
+		{"a@nodata.test", Invalid, "no_mail_domain"},
+		{"a@nxdomain.test", Invalid, "no_mail_domain"},
+		{"a@servfail.test", Unknown, "dns_error"},
+	}
+	c := DNS(r, time.Hour)
+	for _, tt := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		v := c.Check(ctx, "t1", tt.address)
+		cancel()
+		if v.Status != tt.status || v.Reason != tt.reason {
+			t.Errorf("%s: got %s/%s (%s), want %s/%s", tt.address, v.Status, v.Reason, v.Detail, tt.status, tt.reason)
+		}
+	}
+}
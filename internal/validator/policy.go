@@ -0,0 +1,56 @@
+package validator
+
+import "fmt"
+
+// Action is what a ZeroBounce policy does with an address.
+type Action string
+
+const (
+	Reject Action = "reject"
+	Allow  Action = "allow"
+	Flag   Action = "flag" // allow, but mark the address risky
+)
+
+// ZeroBouncePolicy maps a ZeroBounce status, e.g. "catch-all", or a status
+// and sub-status, e.g. "do_not_mail/role_based", to an action.
+type ZeroBouncePolicy map[string]Action
+
+// DefaultZeroBouncePolicy rejects every address ZeroBounce warns against and
+// flags catch-all domains, whose mailboxes cannot be verified.
+var DefaultZeroBouncePolicy = ZeroBouncePolicy{
+	"valid":       Allow,
+	"invalid":     Reject,
+	"catch-all":   Flag,
+	"unknown":     Allow,
+	"spamtrap":    Reject,
+	"abuse":       Reject,
+	"do_not_mail": Reject,
+}
+
+// ParseZeroBouncePolicy checks the actions of a configured policy.
+func ParseZeroBouncePolicy(m map[string]string) (ZeroBouncePolicy, error) {
+	p := ZeroBouncePolicy{}
+	for key, action := range m {
+		switch a := Action(action); a {
+		case Reject, Allow, Flag:
+			p[key] = a
+		default:
+			return nil, fmt.Errorf("invalid action %q for %s, want reject, allow or flag", action, key)
+		}
+	}
+	return p, nil
+}
+
+// action looks the result up in each policy in turn, the sub-status entry
+// before the status one. Statuses no policy knows are flagged.
+func action(res ZeroBounceResult, policies ...ZeroBouncePolicy) Action {
+	for _, p := range policies {
+		if a, ok := p[res.Status+"/"+res.SubStatus]; ok && res.SubStatus != "" {
+			return a
+		}
+		if a, ok := p[res.Status]; ok {
+			return a
+		}
+	}
+	return Flag
+}
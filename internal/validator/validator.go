@@ -1,39 +1,142 @@
 package validator
 
 import (
+	"context"
+	"fmt"
 	"net/mail"
 	"strings"
 )
 
+// Status is how deliverable a check finds an address.
+type Status string
+
+const (
+	Valid   Status = "valid"
+	Risky   Status = "risky"   // deliverable, but worth flagging
+	Unknown Status = "unknown" // the check could not tell
+	Invalid Status = "invalid"
+)
+
+// severity orders statuses for combining verdicts.
+var severity = map[Status]int{Valid: 0, Unknown: 1, Risky: 2, Invalid: 3}
+
+// Verdict is the outcome of validating an address. Reason is a stable code
+// such as "disposable_domain"; Source names the check that gave it.
+type Verdict struct {
+	Status Status `json:"status"`
+	Reason string `json:"reason,omitempty"`
+	Source string `json:"source"`
+	Detail string `json:"detail,omitempty"`
+	// Address is the bare addr-spec that was checked, without any display
+	// name. It is empty when the address does not parse.
+	Address string `json:"-"`
+}
+
+// Rejection is emitted when an event's recipient is rejected.
+type Rejection struct {
+	EventID  string `json:"eventId"`
+	TenantID string `json:"tenantId"`
+	Address  string `json:"address"`
+	Verdict
+}
+
+// Rejected reports whether the address must not be sent to. Risky and
+// unknown addresses are sent to.
+func (v Verdict) Rejected() bool { return v.Status == Invalid }
+
+// Check is one step of validation. Checks run after the syntax check and get
+// the bare addr-spec, without any display name, and the tenant sending to it.
+type Check interface {
+	Name() string
+	Check(ctx context.Context, tenantID, address string) Verdict
+}
+
+// Validator runs a chain of checks on recipient addresses. The chain stops at
+// the first invalid verdict; otherwise the most severe verdict is returned.
 type Validator struct {
-	disposableDomains map[string]struct{}
-	// disposable map[string]struct{}
-	zeroBounce *zeroBounceClient
+	checks  map[string]Check
+	chain   []Check
+	tenants map[string][]Check
 }
 
-func New(disposableDomains []string, zeroBounceClient *zeroBounceClient) *Validator {
-	m := map[string]struct{}{}
-	for _, domain := range disposableDomains {
-		m[strings.ToLower(domain)] = struct{}{}
+// New builds a validator from the available checks. chain names the checks
+// run by default and tenantChains overrides it per tenant, e.g.
+// {"tenant1": ["disposable"]}. The syntax check always runs first and is not
+// named.
+func New(checks []Check, chain []string, tenantChains map[string][]string) (*Validator, error) {
+	v := &Validator{checks: map[string]Check{}, tenants: map[string][]Check{}}
+	for _, c := range checks {
+		v.checks[c.Name()] = c
 	}
-	return &Validator{m, zeroBounceClient}
+	var err error
+	if v.chain, err = v.lookup(chain); err != nil {
+		return nil, err
+	}
+	for tenantID, names := range tenantChains {
+		if v.tenants[tenantID], err = v.lookup(names); err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", tenantID, err)
+		}
+	}
+	return v, nil
 }
 
-func (v *Validator) IsValid(address string) bool {
-	if _, err := mail.ParseAddress(address); err != nil {
-		return false
+func (v *Validator) lookup(names []string) ([]Check, error) {
+	var chain []Check
+	for _, name := range names {
+		c, ok := v.checks[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown validator check %q", name)
+		}
+		chain = append(chain, c)
 	}
+	return chain, nil
+}
 
-	p := strings.Split(address, "@")
-	if len(p) != 2 {
-		return false
+// Validate runs the tenant's chain on the address, which may carry a display
+// name. The verdict carries the bare address to send to.
+func (v *Validator) Validate(ctx context.Context, tenantID, address string) Verdict {
+	a, err := mail.ParseAddress(address)
+	if err != nil {
+		return Verdict{Status: Invalid, Reason: "syntax", Source: "syntax", Detail: err.Error()}
 	}
-	if _, bad := v.disposableDomains[strings.ToLower(p[1])]; bad {
-		return !bad
+	chain, ok := v.tenants[tenantID]
+	if !ok {
+		chain = v.chain
+	}
+	verdict := Verdict{Status: Valid, Source: "syntax", Address: a.Address}
+	for _, c := range chain {
+		got := c.Check(ctx, tenantID, a.Address)
+		got.Address = a.Address
+		if got.Source == "" {
+			got.Source = c.Name()
+		}
+		if got.Rejected() {
+			return got
+		}
+		if severity[got.Status] > severity[verdict.Status] {
+			verdict = got
+		}
+	}
+	return verdict
+}
+
+// Disposable rejects addresses at the given throwaway-mail domains.
+func Disposable(domains []string) Check {
+	m := map[string]struct{}{}
+	for _, domain := range domains {
+		m[strings.ToLower(domain)] = struct{}{}
 	}
+	return disposable(m)
+}
+
+type disposable map[string]struct{}
+
+func (disposable) Name() string { return "disposable" }
 
-	if res := v.zeroBounce.Validate(address); res.Err != nil {
-		return false
+func (d disposable) Check(ctx context.Context, _, address string) Verdict {
+	domain := strings.ToLower(address[strings.LastIndexByte(address, '@')+1:])
+	if _, bad := d[domain]; bad {
+		return Verdict{Status: Invalid, Reason: "disposable_domain", Detail: domain}
 	}
-	return true
+	return Verdict{Status: Valid}
 }
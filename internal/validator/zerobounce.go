@@ -1,32 +1,249 @@
 package validator
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/ilivestrong/email_warmup_service/internal/config"
-	"github.com/zerobounce/zerobouncego"
 )
 
-type (
-	zeroBounceClient          struct{}
-	zeroBoundValidationResult struct {
-		Success bool
-		Err     error
-	}
+// ZeroBounceResult is what ZeroBounce reports about an address.
+type ZeroBounceResult struct {
+	Status    string `json:"status"`
+	SubStatus string `json:"sub_status"`
+}
+
+// defaultCacheTTLs is how long results are cached by status; statuses not
+// listed use the "default" entry. Config can override each.
+var defaultCacheTTLs = map[string]time.Duration{
+	"valid":     30 * 24 * time.Hour,
+	"invalid":   90 * 24 * time.Hour,
+	"catch-all": 7 * 24 * time.Hour,
+	"unknown":   24 * time.Hour,
+	"default":   7 * 24 * time.Hour,
+}
+
+var (
+	errOutOfCredits = errors.New("zerobounce account is out of credits")
+	errBreakerOpen  = errors.New("zerobounce calls suspended after repeated failures")
 )
 
-func NewZeroBounceClient(zbCfg config.ZeroBounceConfig) *zeroBounceClient {
-	zerobouncego.SetApiKey(zbCfg.ApiKey)
-	return &zeroBounceClient{}
+// ZeroBounce validates addresses with the ZeroBounce API. Results are cached
+// per address. When the API fails repeatedly, or the account runs out of
+// credits, addresses are reported unknown instead of invalid, so that an
+// outage does not stop all sending.
+type ZeroBounce struct {
+	apiKey  string
+	baseURL string
+	http    *http.Client
+	cache   ResultCache
+	ttls    map[string]time.Duration
+	breaker *breaker
+	policy  ZeroBouncePolicy
+	tenants map[string]ZeroBouncePolicy
+
+	creditAlert  int
+	outOfCredits atomic.Bool
+}
+
+// NewZeroBounceClient applies the configured policy over
+// DefaultZeroBouncePolicy, and each tenant's policy over that.
+func NewZeroBounceClient(zbCfg config.ZeroBounceConfig, cache ResultCache) (*ZeroBounce, error) {
+	policy, err := ParseZeroBouncePolicy(zbCfg.Policy)
+	if err != nil {
+		return nil, fmt.Errorf("zerobounce policy: %w", err)
+	}
+	tenants := map[string]ZeroBouncePolicy{}
+	for tenantID, m := range zbCfg.TenantPolicies {
+		if tenants[tenantID], err = ParseZeroBouncePolicy(m); err != nil {
+			return nil, fmt.Errorf("zerobounce policy of tenant %s: %w", tenantID, err)
+		}
+	}
+	ttls := map[string]time.Duration{}
+	for status, ttl := range defaultCacheTTLs {
+		ttls[status] = ttl
+	}
+	for status, ttl := range zbCfg.CacheTTLs {
+		ttls[status] = ttl
+	}
+	return &ZeroBounce{
+		apiKey:      zbCfg.ApiKey,
+		baseURL:     strings.TrimSuffix(zbCfg.BaseURL, "/"),
+		http:        &http.Client{Timeout: 10 * time.Second},
+		cache:       cache,
+		ttls:        ttls,
+		breaker:     newBreaker(zbCfg.BreakerThreshold, zbCfg.BreakerCooldown),
+		policy:      policy,
+		tenants:     tenants,
+		creditAlert: zbCfg.CreditAlert,
+	}, nil
 }
 
-func (zbv *zeroBounceClient) Validate(email string) zeroBoundValidationResult {
-	resp, err := zerobouncego.Validate(email, "")
+func (z *ZeroBounce) Name() string { return "zerobounce" }
+
+func (z *ZeroBounce) Check(ctx context.Context, tenantID, email string) Verdict {
+	if z.apiKey == "" {
+		return Verdict{Status: Unknown, Reason: "zerobounce_not_configured"}
+	}
+	res, cached, err := z.cache.Get(ctx, email)
 	if err != nil {
-		return zeroBoundValidationResult{false, fmt.Errorf("failed to validate email: %v", err)}
+		log.Printf("reading zerobounce cache for %s: %v", email, err)
+	}
+	if !cached {
+		if res, err = z.validate(ctx, email); err != nil {
+			return Verdict{Status: Unknown, Reason: "zerobounce_unavailable", Detail: err.Error()}
+		}
+		if err := z.cache.Set(ctx, email, res, z.ttl(res.Status)); err != nil {
+			log.Printf("caching zerobounce result for %s: %v", email, err)
+		}
+	}
+	return z.verdict(tenantID, res)
+}
+
+// verdict applies the tenant's policy to a result. The reason names the
+// status, e.g. "zerobounce_spamtrap", and the detail the sub-status.
+func (z *ZeroBounce) verdict(tenantID string, res ZeroBounceResult) Verdict {
+	v := Verdict{Reason: "zerobounce_" + res.Status, Detail: res.SubStatus}
+	switch action(res, z.tenants[tenantID], z.policy, DefaultZeroBouncePolicy) {
+	case Reject:
+		v.Status = Invalid
+	case Flag:
+		v.Status = Risky
+	case Allow:
+		v.Status = Valid
+		if res.Status == "unknown" {
+			v.Status = Unknown
+		}
+	}
+	if v.Status == Valid && res.SubStatus == "" {
+		v.Reason = ""
+	}
+	return v
+}
+
+func (z *ZeroBounce) ttl(status string) time.Duration {
+	if ttl, ok := z.ttls[status]; ok {
+		return ttl
+	}
+	return z.ttls["default"]
+}
+
+func (z *ZeroBounce) validate(ctx context.Context, email string) (ZeroBounceResult, error) {
+	if z.outOfCredits.Load() {
+		return ZeroBounceResult{}, errOutOfCredits
+	}
+	if !z.breaker.allow() {
+		return ZeroBounceResult{}, errBreakerOpen
+	}
+	var resp struct {
+		ZeroBounceResult
+		Error string `json:"error"`
 	}
-	if resp.Status == zerobouncego.S_INVALID {
-		return zeroBoundValidationResult{false, fmt.Errorf("invalid email: %s", email)}
+	err := z.get(ctx, "/validate", url.Values{"email": {email}, "ip_address": {""}}, &resp)
+	if err == nil && resp.Error != "" {
+		err = errors.New(resp.Error)
 	}
-	return zeroBoundValidationResult{true, nil}
+	z.breaker.done(err == nil)
+	if err != nil {
+		return ZeroBounceResult{}, fmt.Errorf("zerobounce validate: %w", err)
+	}
+	return resp.ZeroBounceResult, nil
+}
+
+// Credits returns the credits left on the account. An empty account stops
+// validation calls until credits are seen again.
+func (z *ZeroBounce) Credits(ctx context.Context) (int, error) {
+	var resp struct {
+		Credits string `json:"Credits"`
+	}
+	if err := z.get(ctx, "/getcredits", nil, &resp); err != nil {
+		return 0, fmt.Errorf("zerobounce credits: %w", err)
+	}
+	n, err := strconv.Atoi(resp.Credits)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("zerobounce credits: unexpected balance %q, check the API key", resp.Credits)
+	}
+	z.outOfCredits.Store(n == 0)
+	return n, nil
+}
+
+// WatchCredits checks the credit balance now and every interval until ctx
+// ends, calling alert when it drops below the configured threshold. The
+// alert is raised once per drop, and again only after the balance has been
+// back at or above the threshold.
+func (z *ZeroBounce) WatchCredits(ctx context.Context, interval time.Duration, alert func(ctx context.Context, credits int)) {
+	if z.apiKey == "" {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	low := false
+	for {
+		low = z.checkCredits(ctx, low, alert)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// checkCredits polls the balance and alerts if it has dropped below the
+// threshold since the last poll. It reports whether the balance is low; a
+// failed poll leaves that unchanged.
+func (z *ZeroBounce) checkCredits(ctx context.Context, low bool, alert func(ctx context.Context, credits int)) bool {
+	n, err := z.Credits(ctx)
+	if err != nil {
+		log.Printf("checking zerobounce credits: %v", err)
+		return low
+	}
+	if n >= z.creditAlert {
+		return false
+	}
+	if !low {
+		log.Printf("zerobounce credits low: %d left, alert threshold %d", n, z.creditAlert)
+		alert(ctx, n)
+	}
+	return true
+}
+
+func (z *ZeroBounce) get(ctx context.Context, path string, params url.Values, out any) error {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("api_key", z.apiKey)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, z.baseURL+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := z.http.Do(req)
+	if err != nil {
+		// The request URL carries the API key, so it is left out.
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			return uerr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// CreditsLow is emitted when the ZeroBounce balance drops below the alert
+// threshold.
+type CreditsLow struct {
+	Credits   int `json:"credits"`
+	Threshold int `json:"threshold"`
 }
@@ -0,0 +1,254 @@
+package validator
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ilivestrong/email_warmup_service/internal/config"
+)
+
+// fakeZeroBounce serves /validate from a table keyed by address and
+// /getcredits from a settable balance.
+type fakeZeroBounce struct {
+	mu       sync.Mutex
+	results  map[string]ZeroBounceResult
+	credits  string
+	failing  bool
+	validate int // /validate calls
+}
+
+func (f *fakeZeroBounce) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Query().Get("api_key") != "test-key" {
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid API key"})
+		return
+	}
+	switch r.URL.Path {
+	case "/validate":
+		f.validate++
+		if f.failing {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		res, ok := f.results[r.URL.Query().Get("email")]
+		if !ok {
+			res = ZeroBounceResult{Status: "valid"}
+		}
+		json.NewEncoder(w).Encode(res)
+	case "/getcredits":
+		json.NewEncoder(w).Encode(map[string]string{"Credits": f.credits})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeZeroBounce) calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.validate
+}
+
+func (f *fakeZeroBounce) set(fn func(f *fakeZeroBounce)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn(f)
+}
+
+func newZeroBounceTest(t *testing.T, cfg config.ZeroBounceConfig) (*ZeroBounce, *fakeZeroBounce) {
+	t.Helper()
+	fake := &fakeZeroBounce{
+		results: map[string]ZeroBounceResult{
+			"valid@example.com":   {Status: "valid"},
+			"alias@example.com":   {Status: "valid", SubStatus: "alias_address"},
+			"gone@example.com":    {Status: "invalid", SubStatus: "mailbox_not_found"},
+			"any@catchall.com":    {Status: "catch-all"},
+			"unknown@example.com": {Status: "unknown", SubStatus: "timeout_exceeded"},
+			"trap@example.com":    {Status: "spamtrap"},
+			"abuse@example.com":   {Status: "abuse"},
+			"info@example.com":    {Status: "do_not_mail", SubStatus: "role_based"},
+			"toxic@example.com":   {Status: "do_not_mail", SubStatus: "toxic"},
+			"new@example.com":     {Status: "some_new_status"},
+		},
+		credits: "1000",
+	}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	cfg.ApiKey, cfg.BaseURL = "test-key", srv.URL
+	if cfg.BreakerThreshold == 0 {
+		cfg.BreakerThreshold, cfg.BreakerCooldown = 5, time.Minute
+	}
+	z, err := NewZeroBounceClient(cfg, newMemoryCache())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return z, fake
+}
+
+func TestZeroBounceStatuses(t *testing.T) {
+	z, _ := newZeroBounceTest(t, config.ZeroBounceConfig{})
+	tests := []struct {
+		address string
+		status  Status
+		reason  string
+		detail  string
+	}{
+		{"valid@example.com", Valid, "", ""},
+		{"alias@example.com", Valid, "zerobounce_valid", "alias_address"},
+		{"gone@example.com", Invalid, "zerobounce_invalid", "mailbox_not_found"},
+		{"any@catchall.com", Risky, "zerobounce_catch-all", ""},
+		{"unknown@example.com", Unknown, "zerobounce_unknown", "timeout_exceeded"},
+		{"trap@example.com", Invalid, "zerobounce_spamtrap", ""},
+		{"abuse@example.com", Invalid, "zerobounce_abuse", ""},
+		{"info@example.com", Invalid, "zerobounce_do_not_mail", "role_based"},
+		{"toxic@example.com", Invalid, "zerobounce_do_not_mail", "toxic"},
+		{"new@example.com", Risky, "zerobounce_some_new_status", ""},
+	}
+	for _, tt := range tests {
+		v := z.Check(context.Background(), "t1", tt.address)
+		if v.Status != tt.status || v.Reason != tt.reason || v.Detail != tt.detail {
+			t.Errorf("%s: got %s/%s/%s, want %s/%s/%s", tt.address, v.Status, v.Reason, v.Detail, tt.status, tt.reason, tt.detail)
+		}
+	}
+}
+
+func TestZeroBouncePolicyPrecedence(t *testing.T) {
+	z, _ := newZeroBounceTest(t, config.ZeroBounceConfig{
+		Policy: map[string]string{
+			"do_not_mail/role_based": "flag",
+			"catch-all":              "allow",
+		},
+		TenantPolicies: map[string]map[string]string{
+			"t2": {"do_not_mail": "allow", "catch-all": "reject"},
+		},
+	})
+	tests := []struct {
+		tenant, address string
+		status          Status
+	}{
+		{"t1", "info@example.com", Risky},    // config sub-status
+		{"t1", "toxic@example.com", Invalid}, // default status
+		{"t1", "any@catchall.com", Valid},    // config status
+		{"t2", "info@example.com", Valid},    // tenant status before config sub-status
+		{"t2", "any@catchall.com", Invalid},  // tenant status
+		{"t2", "trap@example.com", Invalid},  // default status
+	}
+	for _, tt := range tests {
+		if v := z.Check(context.Background(), tt.tenant, tt.address); v.Status != tt.status {
+			t.Errorf("%s %s: got %s, want %s", tt.tenant, tt.address, v.Status, tt.status)
+		}
+	}
+}
+
+func TestZeroBounceCache(t *testing.T) {
+	z, fake := newZeroBounceTest(t, config.ZeroBounceConfig{})
+	ctx := context.Background()
+	z.Check(ctx, "t1", "gone@example.com")
+	z.Check(ctx, "t2", "Gone@Example.com")
+	if n := fake.calls(); n != 1 {
+		t.Fatalf("validate calls = %d, want 1", n)
+	}
+}
+
+func TestZeroBounceBreaker(t *testing.T) {
+	z, fake := newZeroBounceTest(t, config.ZeroBounceConfig{BreakerThreshold: 2, BreakerCooldown: time.Minute})
+	now := time.Date(2025, 1, 2, 9, 0, 0, 0, time.UTC)
+	z.breaker.now = func() time.Time { return now }
+	ctx := context.Background()
+	fake.set(func(f *fakeZeroBounce) { f.failing = true })
+
+	for i := range 3 {
+		v := z.Check(ctx, "t1", "valid@example.com")
+		if v.Status != Unknown || v.Reason != "zerobounce_unavailable" {
+			t.Fatalf("check %d: got %s/%s, want unknown/zerobounce_unavailable", i, v.Status, v.Reason)
+		}
+	}
+	if n := fake.calls(); n != 2 {
+		t.Fatalf("validate calls with the breaker open = %d, want 2", n)
+	}
+
+	// A failed trial call after the cooldown reopens the breaker.
+	now = now.Add(time.Minute)
+	z.Check(ctx, "t1", "valid@example.com")
+	z.Check(ctx, "t1", "valid@example.com")
+	if n := fake.calls(); n != 3 {
+		t.Fatalf("validate calls after a failed trial = %d, want 3", n)
+	}
+
+	// A successful one closes it.
+	now = now.Add(time.Minute)
+	fake.set(func(f *fakeZeroBounce) { f.failing = false })
+	if v := z.Check(ctx, "t1", "valid@example.com"); v.Status != Valid {
+		t.Fatalf("trial call: got %s, want valid", v.Status)
+	}
+	z.Check(ctx, "t1", "gone@example.com")
+	if n := fake.calls(); n != 5 {
+		t.Fatalf("validate calls after recovery = %d, want 5", n)
+	}
+}
+
+func TestZeroBounceCredits(t *testing.T) {
+	z, fake := newZeroBounceTest(t, config.ZeroBounceConfig{})
+	ctx := context.Background()
+
+	if n, err := z.Credits(ctx); err != nil || n != 1000 {
+		t.Fatalf("Credits = %d, %v, want 1000", n, err)
+	}
+
+	fake.set(func(f *fakeZeroBounce) { f.credits = "0" })
+	if n, err := z.Credits(ctx); err != nil || n != 0 {
+		t.Fatalf("Credits = %d, %v, want 0", n, err)
+	}
+	v := z.Check(ctx, "t1", "valid@example.com")
+	if v.Status != Unknown || !strings.Contains(v.Detail, "out of credits") {
+		t.Fatalf("check out of credits: got %s/%s, want unknown", v.Status, v.Detail)
+	}
+	if n := fake.calls(); n != 0 {
+		t.Fatalf("validate calls out of credits = %d, want 0", n)
+	}
+
+	fake.set(func(f *fakeZeroBounce) { f.credits = "50" })
+	z.Credits(ctx)
+	if v := z.Check(ctx, "t1", "valid@example.com"); v.Status != Valid {
+		t.Fatalf("check after top-up: got %s, want valid", v.Status)
+	}
+
+	// ZeroBounce reports -1 credits for a bad key.
+	fake.set(func(f *fakeZeroBounce) { f.credits = "-1" })
+	if _, err := z.Credits(ctx); err == nil {
+		t.Fatal("negative balance accepted")
+	}
+}
+
+func TestZeroBounceNotConfigured(t *testing.T) {
+	z, err := NewZeroBounceClient(config.ZeroBounceConfig{}, newMemoryCache())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := z.Check(context.Background(), "t1", "a@example.com"); v.Status != Unknown || v.Reason != "zerobounce_not_configured" {
+		t.Fatalf("got %s/%s, want unknown/zerobounce_not_configured", v.Status, v.Reason)
+	}
+}
+
+func TestZeroBounceCreditAlert(t *testing.T) {
+	z, fake := newZeroBounceTest(t, config.ZeroBounceConfig{CreditAlert: 100})
+	ctx := context.Background()
+	var alerts []int
+	alert := func(_ context.Context, n int) { alerts = append(alerts, n) }
+
+	low := false
+	for _, credits := range []string{"500", "99", "80", "oops", "60", "100", "90"} {
+		fake.set(func(f *fakeZeroBounce) { f.credits = credits })
+		low = z.checkCredits(ctx, low, alert)
+	}
+	if want := []int{99, 90}; !slices.Equal(alerts, want) {
+		t.Fatalf("alerts = %v, want %v", alerts, want)
+	}
+}
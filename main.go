@@ -5,17 +5,31 @@ import (
 	"fmt"
 	"log"
 	"log/slog"
+	"net"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/ilivestrong/email_warmup_service/internal/clock"
 	"github.com/ilivestrong/email_warmup_service/internal/config"
+	"github.com/ilivestrong/email_warmup_service/internal/content"
+	"github.com/ilivestrong/email_warmup_service/internal/dedup"
+	"github.com/ilivestrong/email_warmup_service/internal/delay"
+	"github.com/ilivestrong/email_warmup_service/internal/history"
+	"github.com/ilivestrong/email_warmup_service/internal/inbox"
+	"github.com/ilivestrong/email_warmup_service/internal/leader"
 	"github.com/ilivestrong/email_warmup_service/internal/processor"
 	"github.com/ilivestrong/email_warmup_service/internal/providers"
 	"github.com/ilivestrong/email_warmup_service/internal/queue"
+	"github.com/ilivestrong/email_warmup_service/internal/queue/events"
 	"github.com/ilivestrong/email_warmup_service/internal/quota"
+	"github.com/ilivestrong/email_warmup_service/internal/reputation"
 	"github.com/ilivestrong/email_warmup_service/internal/resolver"
 	"github.com/ilivestrong/email_warmup_service/internal/scheduler"
+	"github.com/ilivestrong/email_warmup_service/internal/scoring"
+	"github.com/ilivestrong/email_warmup_service/internal/seeds"
+	"github.com/ilivestrong/email_warmup_service/internal/tracking"
 	"github.com/ilivestrong/email_warmup_service/internal/validator"
 )
 
@@ -33,30 +47,165 @@ func main() {
 		log.Fatalf("failed to load config: %v", err)
 	}
 
+	tenantClock, err := clock.NewTenants(clock.System(), cfg.TimezoneMap, cfg.DefaultTimezone)
+	if err != nil {
+		log.Fatalf("timezones: %v", err)
+	}
+
 	// Initialize queue client
-	qClient, err := queue.NewClient(cfg.QueueURL)
+	tenants := make([]string, 0, len(cfg.ProviderMap))
+	for tenantID := range cfg.ProviderMap {
+		tenants = append(tenants, tenantID)
+	}
+	qClient, err := queue.NewClient(cfg.QueueURL, tenants, cfg.TenantWeight)
 	if err != nil {
 		log.Fatalf("queue init error: %v", err)
 	}
 	fmt.Println("listening to events...")
 
-	quotaStore, err := quota.NewStore(cfg.RedisURL)
+	quotaStore, err := quota.NewStore(cfg.QuotaStoreURL)
+	if err != nil {
+		log.Fatalf("quota store: %v", err)
+	}
+
+	historyStore, err := history.NewStore(cfg.HistoryURL)
+	if err != nil {
+		log.Fatalf("history store: %v", err)
+	}
+	defer historyStore.Close()
+
+	dedupStore, err := dedup.NewStore(cfg.RedisURL, cfg.DedupLease, cfg.DedupTTL)
 	if err != nil {
-		log.Fatalf("redis: %v", err)
+		log.Fatalf("dedup store: %v", err)
 	}
 
-	zeroBounceClient := validator.NewZeroBounceClient(cfg.ZeroBounce)
-	emailValidator := validator.New(cfg.Validator.DisposableDomains, zeroBounceClient)
+	delayStore := delay.NewMemoryStore()
+	if cfg.RedisURL != "" {
+		if delayStore, err = delay.NewRedisStore(cfg.RedisURL); err != nil {
+			log.Fatalf("delay store: %v", err)
+		}
+	}
+	go delay.Run(ctx, delayStore, cfg.DelayPollInterval, qClient.Publish)
+
+	zbCache, err := validator.NewResultCache(cfg.RedisURL)
+	if err != nil {
+		log.Fatalf("zerobounce cache: %v", err)
+	}
+	zeroBounceClient, err := validator.NewZeroBounceClient(cfg.ZeroBounce, zbCache)
+	if err != nil {
+		log.Fatalf("zerobounce: %v", err)
+	}
+	emailValidator, err := validator.New(
+		[]validator.Check{
+			validator.Disposable(cfg.Validator.DisposableDomains),
+			validator.DNS(net.DefaultResolver, cfg.Validator.DNSCacheTTL),
+			zeroBounceClient,
+		},
+		cfg.Validator.Checks, cfg.Validator.TenantChecks,
+	)
+	if err != nil {
+		log.Fatalf("validator: %v", err)
+	}
 
 	provFactory := providers.NewFactory(cfg.ProviderMap, cfg.SMTP, cfg.GoogleOAuth)
 	addrRes := resolver.NewStatic(cfg.SenderMap)
-	processor := processor.New(quotaStore, emailValidator, provFactory, addrRes, qClient, cfg.RetryPolicy, logger)
+	scoreModel, err := scoring.New(cfg.ScoreWeights)
+	if err != nil {
+		log.Fatalf("scoring: %v", err)
+	}
+
+	inboxes := inbox.NewDetector(net.DefaultResolver)
+	seedPool, err := seeds.LoadFile(cfg.SeedPoolFile)
+	if err != nil {
+		log.Fatalf("seed pool: %v", err)
+	}
+	library, err := content.LoadFile(cfg.ContentTemplatesFile)
+	if err != nil {
+		log.Fatalf("content templates: %v", err)
+	}
+	contentGen := content.NewGenerator(library, dedupStore)
+	responder := seeds.NewResponder(seedPool, cfg.SeedReplyRate, cfg.SeedReplyMinDelay, cfg.SeedReplyMaxDelay, delayStore, contentGen)
+	rescuer := seeds.NewRescuer(seedPool, cfg.SeedRescueDelay, delayStore, cfg.TrackingLabel)
+	tagger := tracking.New(cfg.TrackingSecret, cfg.TrackingBodyCode)
+
+	processor := processor.New(processor.Deps{
+		Quota:     quotaStore,
+		Validator: emailValidator,
+		Providers: provFactory,
+		Resolver:  addrRes,
+		Queue:     qClient,
+		Retry:     cfg.RetryPolicy,
+		Clock:     tenantClock,
+		Inboxes:   inboxes,
+		Scores:    scoreModel,
+		History:   historyStore,
+		Dedup:     dedupStore,
+		Delayed:   delayStore,
+		Replies:   responder,
+		Rescuer:   rescuer,
+		Tags:      tagger,
+		Log:       logger,
+	})
 	for i := 0; i < cfg.WorkerCount; i++ {
 		go processor.Start(ctx)
 	}
 
-	sched := scheduler.NewScheduler(cfg, quotaStore, provFactory)
-	go sched.StartDaily(ctx)
+	repEngine := reputation.New(quotaStore, cfg.ReputationHalfLifeDays, cfg.ReputationMinSamples)
+	var leadership scheduler.Leadership = leader.Solo{}
+	if cfg.RedisURL != "" {
+		elector, err := leader.New(cfg.RedisURL, "scheduler", cfg.InstanceID, cfg.LeaderLeaseTTL, logger)
+		if err != nil {
+			log.Fatalf("leader election: %v", err)
+		}
+		go elector.Run(ctx)
+		leadership = elector
+	}
+	// Every replica tracks the balance, but only the leader raises alerts.
+	go zeroBounceClient.WatchCredits(ctx, cfg.ZeroBounce.CreditsInterval, func(ctx context.Context, credits int) {
+		if !leadership.IsLeader() {
+			return
+		}
+		low := validator.CreditsLow{Credits: credits, Threshold: cfg.ZeroBounce.CreditAlert}
+		if err := qClient.Emit(ctx, events.KindCreditsLow, low); err != nil {
+			log.Printf("failed to emit zerobounce credits alert: %v", err)
+		}
+	})
+
+	sched := scheduler.NewScheduler(cfg, quotaStore, provFactory, addrRes, tenantClock, scoreModel, repEngine, qClient, leadership)
+	err = sched.Register(ctx, scheduler.Job{
+		Name: "history-retention",
+		Spec: "@daily",
+		Run: func(ctx context.Context, at time.Time) error {
+			n, err := historyStore.Purge(ctx, at.Add(-cfg.HistoryRetention))
+			log.Printf("purged %d history records", n)
+			return err
+		},
+	})
+	if err != nil {
+		log.Fatalf("history retention: %v", err)
+	}
+	if cfg.SeedPoolFile != "" || len(cfg.PeerPoolTenants) > 0 {
+		ledger, err := seeds.NewLedger(cfg.RedisURL)
+		if err != nil {
+			log.Fatalf("peer pool: %v", err)
+		}
+		peers := seeds.NewPeerPool(cfg.PeerPoolTenants, addrRes, ledger, cfg.PeerPairCap)
+		planner := seeds.NewPlanner(seedPool, peers, quotaStore, addrRes, tenantClock, qClient, contentGen)
+		for tenantID := range cfg.SenderMap {
+			err := sched.Register(ctx, scheduler.Job{
+				Name:     "seed-plan:" + tenantID,
+				Spec:     cfg.SeedPlanCron,
+				Location: tenantClock.Location(tenantID),
+				Run: func(ctx context.Context, at time.Time) error {
+					return planner.Plan(ctx, tenantID, at)
+				},
+			})
+			if err != nil {
+				log.Fatalf("seed planner: %v", err)
+			}
+		}
+	}
+	go sched.Start(ctx)
 
 	<-ctx.Done()
 	log.Println("shutting down the service")